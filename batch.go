@@ -0,0 +1,303 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	errs "errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	protogen "github.com/aluka-7/trace/proto"
+)
+
+const (
+	// 默认批量刷新间隔
+	defaultFlushInterval = time.Second
+	// 只支持批量发送的上报器默认每批最多的 span 数量
+	defaultBatchSize = 100
+)
+
+var (
+	errBatchCorrupted = errs.New("trace: batch data corrupted")
+	errReportClosed   = errs.New("report already closed")
+)
+
+// batcher 按条数、字节数或时间间隔聚合待发送的数据,并交给 flush 统一发送.
+// 所有数据均由单个 daemon 协程处理,flush 不需要考虑并发.
+// flush 收到的 ctx 结束时应尽快放弃发送:Flush 请求使用调用方的 ctx,其余使用 batcher 的 ctx,shutdown 超时时取消.
+type batcher struct {
+	maxCount int
+	maxBytes int
+	// overhead 每条数据在批量编码中额外占用的字节数
+	overhead func(n int) int
+	interval time.Duration
+	flush    func(ctx context.Context, batch [][]byte)
+	// onTick 不为空时每隔 interval 在 daemon 协程中调用一次
+	onTick func(ctx context.Context)
+	// onFlush 不为空时在 flushContext 发送完队列中的数据后,在 daemon 协程中调用
+	onFlush func(ctx context.Context) error
+
+	rmx     sync.RWMutex
+	closed  bool
+	dataCh  chan []byte
+	flushCh chan flushRequest
+	done    chan struct{}
+	stats   *reportStats
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// flushRequest 要求 daemon 立即发送已进入队列的数据,完成后将结果写入 done.
+type flushRequest struct {
+	ctx  context.Context
+	done chan error
+}
+
+func newBatcher(maxCount, maxBytes int, interval time.Duration, flush func(ctx context.Context, batch [][]byte)) *batcher {
+	if maxCount <= 0 {
+		maxCount = 1
+	}
+	if maxBytes <= 0 {
+		maxBytes = maxPackageSize
+	}
+	b := &batcher{
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+		overhead: func(int) int { return 0 },
+		interval: interval,
+		flush:    flush,
+		dataCh:   make(chan []byte, dataChSize),
+		flushCh:  make(chan flushRequest),
+		done:     make(chan struct{}),
+		stats:    &reportStats{},
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b
+}
+
+// batchConfig 返回 Config 中的批量参数,BatchSize 小于等于 1 时使用 defaultBatchSize,
+// FlushInterval 为 0 时使用 defaultFlushInterval.
+func batchConfig(cfg *Config) (size int, interval time.Duration) {
+	size = cfg.BatchSize
+	if size <= 1 {
+		size = defaultBatchSize
+	}
+	interval = time.Duration(cfg.FlushInterval)
+	if interval == 0 {
+		interval = defaultFlushInterval
+	}
+	return
+}
+
+// configTimeout 返回 Config 中的超时时间,为 0 时使用上报器的默认值 def.
+func configTimeout(cfg *Config, def time.Duration) time.Duration {
+	if timeout := time.Duration(cfg.Timeout); timeout != 0 {
+		return timeout
+	}
+	return def
+}
+
+// write 将数据放入发送队列,队列满时最多等待 defaultWriteChannelTimeout.
+func (b *batcher) write(data []byte) error {
+	b.rmx.RLock()
+	defer b.rmx.RUnlock()
+	if b.closed {
+		atomic.AddInt64(&b.stats.droppedClosed, 1)
+		return errReportClosed
+	}
+	select {
+	case b.dataCh <- data:
+		atomic.AddInt64(&b.stats.queued, 1)
+		return nil
+	case <-time.After(defaultWriteChannelTimeout):
+		atomic.AddInt64(&b.stats.droppedTimeout, 1)
+		return fmt.Errorf("write to data channel timeout")
+	}
+}
+
+// Stats 返回发送队列的统计数据.
+func (b *batcher) Stats() ReportStats {
+	stats := b.stats.snapshot()
+	stats.QueueDepth = int64(len(b.dataCh))
+	return stats
+}
+
+// flushContext 立即发送调用前已进入队列的数据,并等待发送完成或者 ctx 结束.
+func (b *batcher) flushContext(ctx context.Context) error {
+	req := flushRequest{ctx: ctx, done: make(chan error, 1)}
+	b.rmx.RLock()
+	if b.closed {
+		b.rmx.RUnlock()
+		return errReportClosed
+	}
+	select {
+	case b.flushCh <- req:
+		b.rmx.RUnlock()
+	case <-ctx.Done():
+		b.rmx.RUnlock()
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown 停止接收数据并等待队列中的数据发送完毕.
+// ctx 结束时取消正在进行的发送,等待 daemon 退出后返回错误,剩余的数据丢弃.
+// 除了重复关闭,返回时 daemon 均已退出,调用方可以安全地释放 flush 使用的资源.
+func (b *batcher) shutdown(ctx context.Context) error {
+	b.rmx.Lock()
+	if b.closed {
+		b.rmx.Unlock()
+		return errReportClosed
+	}
+	b.closed = true
+	b.rmx.Unlock()
+
+	close(b.dataCh)
+	defer b.cancel()
+	select {
+	case <-ctx.Done():
+		b.cancel()
+		<-b.done
+		return fmt.Errorf("close report timeout force close: %w", ctx.Err())
+	case <-b.done:
+		return nil
+	}
+}
+
+func (b *batcher) daemon() {
+	var (
+		batch [][]byte
+		size  int
+		tick  <-chan time.Time
+	)
+	if (b.maxCount > 1 || b.onTick != nil) && b.interval > 0 {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	emit := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		b.flush(ctx, batch)
+		batch, size = nil, 0
+	}
+	add := func(data []byte) {
+		n := len(data) + b.overhead(len(data))
+		if size+n > b.maxBytes {
+			emit(b.ctx)
+		}
+		batch = append(batch, data)
+		size += n
+		if len(batch) >= b.maxCount || size >= b.maxBytes {
+			emit(b.ctx)
+		}
+	}
+	for {
+		select {
+		case data, ok := <-b.dataCh:
+			if !ok {
+				emit(b.ctx)
+				close(b.done)
+				return
+			}
+			add(data)
+		case req := <-b.flushCh:
+			// 先取出请求之前已经进入队列的数据
+			for pending := true; pending; {
+				select {
+				case data, ok := <-b.dataCh:
+					if pending = ok; ok {
+						add(data)
+					}
+				default:
+					pending = false
+				}
+			}
+			emit(req.ctx)
+			var err error
+			if b.onFlush != nil {
+				err = b.onFlush(req.ctx)
+			}
+			req.done <- err
+		case <-tick:
+			emit(b.ctx)
+			if b.onTick != nil {
+				b.onTick(b.ctx)
+			}
+		}
+	}
+}
+
+// appendServiceItem 将服务名编码在 payload 之前.
+// 同一批次中可能包含不同服务的 span,按服务分组上报的上报器以此为队列中的数据格式.
+func appendServiceItem(service string, payload []byte) []byte {
+	data := make([]byte, 0, uvarintSize(len(service))+len(service)+len(payload))
+	data = appendUvarint(data, uint64(len(service)))
+	data = append(data, service...)
+	return append(data, payload...)
+}
+
+// groupByService 按服务名对 appendServiceItem 编码的数据分组,服务保持首次出现的顺序.
+func groupByService(batch [][]byte) (services []string, groups map[string][][]byte) {
+	groups = make(map[string][][]byte)
+	for _, data := range batch {
+		length, n := binary.Uvarint(data)
+		service := string(data[n : n+int(length)])
+		if _, ok := groups[service]; !ok {
+			services = append(services, service)
+		}
+		groups[service] = append(groups[service], data[n+int(length):])
+	}
+	return
+}
+
+// uvarintSize 返回 n 按 uvarint 编码后的字节数.
+func uvarintSize(n int) int {
+	size := 1
+	for v := uint64(n); v >= 0x80; v >>= 7 {
+		size++
+	}
+	return size
+}
+
+// encodeBatch 将多个已序列化的 span 编码为一个批量包,每个 span 为一帧,见 appendFrame.
+func encodeBatch(version int32, batch [][]byte) []byte {
+	size := 0
+	for _, data := range batch {
+		size += frameSize(len(data))
+	}
+	buf := make([]byte, 0, size)
+	for _, data := range batch {
+		buf = appendFrame(buf, version, 0, data)
+	}
+	return buf
+}
+
+// UnmarshalBatch 解码批量上报的数据包,返回其中的全部 span.
+func UnmarshalBatch(data []byte) ([]*protogen.Span, error) {
+	var spans []*protogen.Span
+	r := NewSpanReader(bytes.NewReader(data))
+	for {
+		sp, err := r.Read()
+		if err == io.EOF {
+			return spans, nil
+		}
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errBatchCorrupted
+			}
+			return spans, err
+		}
+		spans = append(spans, sp)
+	}
+}
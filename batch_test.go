@@ -0,0 +1,140 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher(t *testing.T) {
+	t.Run("test flush by count", func(t *testing.T) {
+		var batches [][][]byte
+		b := newBatcher(2, 0, 0, func(_ context.Context, batch [][]byte) { batches = append(batches, batch) })
+		go b.daemon()
+		for i := 0; i < 5; i++ {
+			b.dataCh <- []byte{byte(i)}
+		}
+		close(b.dataCh)
+		<-b.done
+		assert.Len(t, batches, 3)
+		assert.Len(t, batches[2], 1)
+	})
+	t.Run("test flush by bytes", func(t *testing.T) {
+		var batches [][][]byte
+		b := newBatcher(100, 10, 0, func(_ context.Context, batch [][]byte) { batches = append(batches, batch) })
+		go b.daemon()
+		for i := 0; i < 3; i++ {
+			b.dataCh <- make([]byte, 6)
+		}
+		close(b.dataCh)
+		<-b.done
+		assert.Len(t, batches, 3)
+	})
+	t.Run("test flush by interval", func(t *testing.T) {
+		flushed := make(chan [][]byte, 1)
+		b := newBatcher(100, 0, 10*time.Millisecond, func(_ context.Context, batch [][]byte) { flushed <- batch })
+		go b.daemon()
+		b.dataCh <- []byte("hello")
+		select {
+		case batch := <-flushed:
+			assert.Equal(t, [][]byte{[]byte("hello")}, batch)
+		case <-time.After(time.Second):
+			t.Fatal("batch not flushed by interval")
+		}
+		close(b.dataCh)
+		<-b.done
+	})
+}
+
+func TestReportBatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cancel, err := newServer(buf, "tcp", "127.0.0.1:6078")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6078", ProtocolVersion: protoVersion1, BatchSize: 16, FlushInterval: utils.Duration(time.Minute)})
+	t1 := NewTracer("service1", nil, report, true)
+	for i := 0; i < 10; i++ {
+		t1.New("opt_batch").SetTag(TagInt("index", i)).Finish(nil)
+	}
+	if err := report.Close(); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	spans, err := UnmarshalBatch(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, spans, 10)
+	for _, sp := range spans {
+		assert.Equal(t, "service1", sp.ServiceName)
+		assert.Equal(t, "opt_batch", sp.OperationName)
+	}
+}
+
+func TestUnmarshalBatchCorrupted(t *testing.T) {
+	data := encodeBatch(protoVersion1, [][]byte{[]byte("hello")})
+	_, err := UnmarshalBatch(data[:3])
+	assert.Equal(t, errBatchCorrupted, err)
+}
+
+func TestBatcherFlushContext(t *testing.T) {
+	var batches [][][]byte
+	b := newBatcher(100, 0, time.Minute, func(_ context.Context, batch [][]byte) { batches = append(batches, batch) })
+	flushed := false
+	b.onFlush = func(ctx context.Context) error {
+		flushed = true
+		return nil
+	}
+	go b.daemon()
+	for i := 0; i < 3; i++ {
+		b.write([]byte{byte(i)})
+	}
+	assert.Nil(t, b.flushContext(context.Background()))
+	assert.Len(t, batches, 1)
+	assert.Len(t, batches[0], 3)
+	assert.True(t, flushed)
+	assert.Nil(t, b.shutdown(context.Background()))
+	assert.NotNil(t, b.flushContext(context.Background()))
+	assert.NotNil(t, b.shutdown(context.Background()))
+}
+
+func TestBatcherShutdownTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	b := newBatcher(1, 0, 0, func(ctx context.Context, batch [][]byte) {
+		// 模拟一直无法完成的发送
+		<-ctx.Done()
+		close(canceled)
+	})
+	go b.daemon()
+	b.write([]byte("hello"))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.shutdown(ctx), context.DeadlineExceeded)
+	// 返回时正在进行的发送已被取消,daemon 已经退出
+	select {
+	case <-canceled:
+	default:
+		t.Fatal("flush not canceled")
+	}
+	select {
+	case <-b.done:
+	default:
+		t.Fatal("daemon still running")
+	}
+}
+
+func TestBatchConfig(t *testing.T) {
+	size, interval := batchConfig(&Config{BatchSize: 1})
+	assert.Equal(t, defaultBatchSize, size)
+	assert.Equal(t, defaultFlushInterval, interval)
+	size, interval = batchConfig(&Config{BatchSize: 16, FlushInterval: utils.Duration(time.Minute)})
+	assert.Equal(t, 16, size)
+	assert.Equal(t, time.Minute, interval)
+	assert.Equal(t, defaultWriteTimeout, configTimeout(&Config{}, defaultWriteTimeout))
+	assert.Equal(t, time.Second, configTimeout(&Config{Timeout: utils.Duration(time.Second)}, defaultWriteTimeout))
+}
@@ -0,0 +1,81 @@
+package trace
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// compressFlag 返回压缩算法对应的帧标记,为空时不压缩.
+func compressFlag(compression string) (byte, error) {
+	switch compression {
+	case "":
+		return 0, nil
+	case "gzip":
+		return frameFlagGzip, nil
+	case "deflate":
+		return frameFlagDeflate, nil
+	default:
+		return 0, fmt.Errorf("trace: unsupported compression %q", compression)
+	}
+}
+
+// compressor 复用压缩器的内部缓冲,只能在单个协程中使用.
+type compressor struct {
+	flag byte
+	buf  bytes.Buffer
+	w    interface {
+		io.WriteCloser
+		Reset(w io.Writer)
+	}
+}
+
+func newCompressor(flag byte) *compressor {
+	c := &compressor{flag: flag}
+	if flag == frameFlagGzip {
+		c.w = gzip.NewWriter(&c.buf)
+	} else {
+		c.w, _ = flate.NewWriter(&c.buf, flate.DefaultCompression)
+	}
+	return c
+}
+
+// compressFrames 将一组帧压缩为一个带有压缩标记的帧,压缩后没有变小时原样返回.
+func (c *compressor) compressFrames(version int32, frames []byte) []byte {
+	c.buf.Reset()
+	c.w.Reset(&c.buf)
+	c.w.Write(frames)
+	if err := c.w.Close(); err != nil || frameSize(c.buf.Len()) >= len(frames) {
+		return frames
+	}
+	return appendFrame(make([]byte, 0, frameSize(c.buf.Len())), version, c.flag, c.buf.Bytes())
+}
+
+// decompress 解压压缩帧的 payload,解压后的大小同样受 maxFrameSize 限制.
+func decompress(flags byte, payload []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+	switch flags & frameFlagCompressed {
+	case frameFlagGzip:
+		if r, err = gzip.NewReader(bytes.NewReader(payload)); err != nil {
+			return nil, err
+		}
+	case frameFlagDeflate:
+		r = flate.NewReader(bytes.NewReader(payload))
+	default:
+		return nil, fmt.Errorf("trace: unsupported frame flags %#x", flags)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFrameSize {
+		return nil, errFrameTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,101 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress(t *testing.T) {
+	t1 := NewTracer("service1", nil, &mockReport{}, true)
+	var batch [][]byte
+	for i := 0; i < 10; i++ {
+		sp := t1.New("opt_compress").SetTag(TagString(TagDBStatement, strings.Repeat("select 1;", 20))).(*Span)
+		data, err := marshalSpan(newSpanData(sp), protoVersion1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, data)
+	}
+	frames := encodeBatch(protoVersion1, batch)
+	for _, name := range []string{"gzip", "deflate"} {
+		t.Run("test "+name, func(t *testing.T) {
+			flag, err := compressFlag(name)
+			assert.Nil(t, err)
+			data := newCompressor(flag).compressFrames(protoVersion1, frames)
+			assert.True(t, len(data) < len(frames))
+			assert.Equal(t, flag, data[1])
+			spans, err := UnmarshalBatch(data)
+			assert.Nil(t, err)
+			assert.Len(t, spans, 10)
+			_, err = ReadSpan(bytes.NewReader(data))
+			assert.Equal(t, errFrameCompressed, err)
+		})
+	}
+	t.Run("test incompressible", func(t *testing.T) {
+		data := encodeBatch(protoVersion1, [][]byte{{1}})
+		assert.Equal(t, data, newCompressor(frameFlagGzip).compressFrames(protoVersion1, data))
+	})
+	t.Run("test unsupported", func(t *testing.T) {
+		_, err := compressFlag("snappy")
+		assert.NotNil(t, err)
+	})
+	t.Run("test span reader mixed frames", func(t *testing.T) {
+		stream := append(newCompressor(frameFlagGzip).compressFrames(protoVersion1, frames), frames...)
+		r := NewSpanReader(bytes.NewReader(stream))
+		n := 0
+		for {
+			_, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		assert.Equal(t, 20, n)
+	})
+}
+
+func TestReportCompression(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cancel, err := newServer(buf, "tcp", "127.0.0.1:6084")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newConnReport(&Config{
+		Network:         "tcp",
+		Addr:            "127.0.0.1:6084",
+		ProtocolVersion: protoVersion1,
+		BatchSize:       16,
+		FlushInterval:   utils.Duration(time.Minute),
+		Compression:     "gzip",
+	})
+	t1 := NewTracer("service1", nil, report, true)
+	for i := 0; i < 10; i++ {
+		t1.New("opt_compress").SetTag(TagString(TagDBStatement, fmt.Sprintf("select %d from dual", i))).Finish(nil)
+	}
+	if err := report.Close(); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	// 连接上先写入 Resource 帧,之后为压缩的批量包
+	r := bytes.NewReader(buf.Bytes())
+	_, flags, _, _ := readFrame(r)
+	assert.Equal(t, frameFlagResource, flags)
+	_, flags, _, _ = readFrame(r)
+	assert.Equal(t, frameFlagGzip, flags)
+	spans, err := UnmarshalBatch(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, spans, 10)
+	assert.Equal(t, int64(10), report.Stats().Sent)
+}
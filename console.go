@@ -0,0 +1,158 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 等待本地根 span 结束的 trace 数量上限,超过后立即输出全部未完成的 trace
+const consoleMaxPending = 1024
+
+// consoleSpan 输出时所需的 span 信息,tag 与 log 在 WriteSpan 中预先格式化,等待 trace 结束期间只保留输出需要的内容.
+type consoleSpan struct {
+	service   string
+	operation string
+	spanId    uint64
+	parentId  uint64
+	startTime time.Time
+	duration  time.Duration
+	failed    bool
+	tags      []string
+	logs      []string
+}
+
+// newConsoleReport 创建在本地开发时使用的上报器,
+// 每个 trace 的本地根 span 结束后,将该 trace 以缩进的树形结构输出到 w.
+func newConsoleReport(w io.Writer) *consoleReport {
+	return &consoleReport{w: w, pending: make(map[uint64][]*consoleSpan)}
+}
+
+type consoleReport struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending map[uint64][]*consoleSpan
+	// order 按 trace 首个 span 结束的顺序记录 trace,保证输出顺序稳定
+	order []uint64
+}
+
+func (c *consoleReport) WriteSpan(sd *SpanData) error {
+	cs := &consoleSpan{
+		service:   sd.ServiceName,
+		operation: sd.OperationName,
+		spanId:    sd.SpanId,
+		parentId:  sd.ParentId,
+		startTime: sd.StartTime,
+		duration:  sd.Duration,
+	}
+	for _, tag := range sd.spanTags() {
+		if tag.Key == TagError {
+			cs.failed, _ = tag.Value.(bool)
+			continue
+		}
+		cs.tags = append(cs.tags, fmt.Sprintf("%s=%v", tag.Key, tag.Value))
+	}
+	for _, log := range sd.Logs {
+		fields := make([]string, len(log.Fields))
+		for i, field := range log.Fields {
+			fields[i] = field.Key + "=" + strconv.Quote(logValueString(field.Value))
+		}
+		ts := log.Timestamp.Format("15:04:05.000")
+		cs.logs = append(cs.logs, ts+" "+strings.Join(fields, " "))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	traceId := sd.TraceId
+	if _, ok := c.pending[traceId]; !ok {
+		c.order = append(c.order, traceId)
+	}
+	c.pending[traceId] = append(c.pending[traceId], cs)
+	// New 与 Extract 创建的 span 层级为 1,即本进程内的根 span
+	if sd.level == 1 {
+		return c.print(traceId)
+	}
+	if len(c.pending) > consoleMaxPending {
+		return c.printAll()
+	}
+	return nil
+}
+
+func (c *consoleReport) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.printAll()
+}
+
+func (c *consoleReport) printAll() error {
+	for len(c.order) > 0 {
+		if err := c.print(c.order[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print 输出 trace 并将其从等待列表中移除.父 span 不在本 trace 中的 span 均作为根输出.
+func (c *consoleReport) print(traceId uint64) error {
+	spans := c.pending[traceId]
+	delete(c.pending, traceId)
+	for i, id := range c.order {
+		if id == traceId {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].startTime.Before(spans[j].startTime) })
+	ids := make(map[uint64]bool, len(spans))
+	for _, sp := range spans {
+		ids[sp.spanId] = true
+	}
+	children := make(map[uint64][]*consoleSpan)
+	var roots []*consoleSpan
+	for _, sp := range spans {
+		if sp.parentId != 0 && ids[sp.parentId] {
+			children[sp.parentId] = append(children[sp.parentId], sp)
+		} else {
+			roots = append(roots, sp)
+		}
+	}
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "trace %016x\n", traceId)
+	var write func(sp *consoleSpan, depth int)
+	write = func(sp *consoleSpan, depth int) {
+		indent := strings.Repeat("  ", depth+1)
+		fmt.Fprintf(buf, "%s%s %s %s", indent, sp.service, sp.operation, sp.duration)
+		if sp.failed {
+			buf.WriteString(" [ERROR]")
+		}
+		if len(sp.tags) > 0 {
+			buf.WriteString(" " + strings.Join(sp.tags, " "))
+		}
+		buf.WriteString("\n")
+		for _, log := range sp.logs {
+			fmt.Fprintf(buf, "%s  | %s\n", indent, log)
+		}
+		for _, child := range children[sp.spanId] {
+			write(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		write(root, 0)
+	}
+	_, err := io.WriteString(c.w, buf.String())
+	return err
+}
+
+// consoleWriter 根据地址选择输出,stderr 输出到标准错误,其他输出到标准输出.
+func consoleWriter(addr string) io.Writer {
+	if addr == "stderr" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -0,0 +1,49 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleReport(t *testing.T) {
+	t.Run("test print tree", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		report := newConsoleReport(buf)
+		t1 := NewTracer("service1", nil, report, true)
+		sp1 := t1.New("opt_server")
+		sp2 := sp1.Fork("", "opt_client")
+		sp3 := sp2.Fork("", "opt_redis").SetTag(TagString(TagPeerService, "redis"))
+		err := fmt.Errorf("redis timeout")
+		sp3.Finish(&err)
+		sp2.Finish(nil)
+		assert.Empty(t, buf.String(), "print after local root finished")
+		sp1.Finish(nil)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		assert.Len(t, lines, 5)
+		assert.True(t, strings.HasPrefix(lines[0], "trace "))
+		assert.True(t, strings.HasPrefix(lines[1], "  service1 opt_server "))
+		assert.Contains(t, lines[1], "span.kind=server")
+		assert.True(t, strings.HasPrefix(lines[2], "    service1 opt_client "))
+		assert.True(t, strings.HasPrefix(lines[3], "      service1 opt_redis "))
+		assert.Contains(t, lines[3], "[ERROR]")
+		assert.Contains(t, lines[3], "peer.service=redis")
+		assert.True(t, strings.HasPrefix(lines[4], "        | "))
+		assert.Contains(t, lines[4], `message="redis timeout"`)
+	})
+	t.Run("test close print pending", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		report := newConsoleReport(buf)
+		t1 := NewTracer("service1", nil, report, true)
+		sp1 := t1.New("opt_server")
+		sp1.Fork("", "opt_client").Finish(nil)
+		assert.Empty(t, buf.String())
+		report.Close()
+		assert.Contains(t, buf.String(), "  service1 opt_client ")
+		assert.Empty(t, report.pending)
+	})
+}
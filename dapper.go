@@ -1,12 +1,17 @@
 package trace
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc/metadata"
+
+	protogen "github.com/aluka-7/trace/proto"
 )
 
 // Carrier 传播者必须将通用接口{}转换为此实现Carrier接口的东西,Trace可以使用Carrier表示自己。
@@ -93,14 +98,19 @@ func (gRpcPropagator) Extract(carrier interface{}) (Carrier, error) {
 }
 
 type dapper struct {
-	serviceName   string
+	serviceName string
+	// resource 描述当前进程,由上报器按批量包上报
+	resource      *Resource
 	disableSample bool
-	tags          []Tag
-	reporter      reporter
-	propagators   map[interface{}]propagator
-	pool          *sync.Pool
-	stdLog        *log.Logger
-	sampler       sampler
+	// strict 为 true 时对已结束 span 的调用输出堆栈,见 Config.StrictSpan
+	strict bool
+	// lateCalls 对已结束 span 的调用次数,原子操作
+	lateCalls   int64
+	reporter    reporter
+	propagators map[interface{}]propagator
+	pool        *sync.Pool
+	stdLog      *log.Logger
+	sampler     sampler
 }
 
 func (d *dapper) New(operationName string, opts ...Option) Trace {
@@ -124,19 +134,26 @@ func (d *dapper) New(operationName string, opts ...Option) Trace {
 	}
 	if opt.Debug {
 		ctx.Flags |= flagDebug
-		return d.newSpanWithContext(operationName, ctx).SetTag(TagString(TagSpanKind, "server")).SetTag(TagBool("debug", true))
+	}
+	t := d.newSpanWithContext(operationName, ctx)
+	if sp, ok := t.(*Span); ok {
+		sp.serviceName = opt.ServiceName
 	}
 	// 为了兼容临时为 New 的 Span 设置 span.kind
-	return d.newSpanWithContext(operationName, ctx).SetTag(TagString(TagSpanKind, "server"))
+	t.SetTag(TagString(TagSpanKind, "server"))
+	if opt.Debug {
+		t.SetTag(TagBool("debug", true))
+	}
+	return t
 }
 
 func (d *dapper) newSpanWithContext(operationName string, ctx spanContext) Trace {
-	sp := d.getSpan()
 	// 如果未采样范围,则仅返回具有此上下文的范围,无需清除它
 	if ctx.Level > maxLevel {
 		// 如果跨度达到最大限制水平，则返回noopSpan
 		return noopSpan{}
 	}
+	sp := d.getSpan()
 	level := ctx.Level + 1
 	sc := spanContext{
 		TraceId:  ctx.TraceId,
@@ -152,7 +169,6 @@ func (d *dapper) newSpanWithContext(operationName string, ctx spanContext) Trace
 	sp.operationName = operationName
 	sp.context = sc
 	sp.startTime = time.Now()
-	sp.tags = append(sp.tags, d.tags...)
 	return sp
 }
 
@@ -212,30 +228,54 @@ func (d *dapper) Close() error {
 	return d.reporter.Close()
 }
 
-func (d *dapper) report(sp *Span) {
-	if sp.context.isSampled() {
-		if err := d.reporter.WriteSpan(sp); err != nil {
+// Flush 等待已结束的 span 发送完毕,或者直到 ctx 结束.
+func (d *dapper) Flush(ctx context.Context) error {
+	return flushReporter(ctx, d.reporter)
+}
+
+// Shutdown 关闭上报器,等待已结束的 span 发送完毕,或者直到 ctx 结束.
+func (d *dapper) Shutdown(ctx context.Context) error {
+	return shutdownReporter(ctx, d.reporter)
+}
+
+// report 上报 span 结束时的快照 sd,sd 为空时只回收 span.
+func (d *dapper) report(state *spanState, sd *SpanData) {
+	if sd != nil {
+		if err := d.reporter.WriteSpan(sd); err != nil {
 			d.stdLog.Printf("marshal trace span error: %s", err)
 		}
 	}
-	d.putSpan(sp)
+	d.putSpan(state)
 }
 
-func (d *dapper) putSpan(sp *Span) {
-	if len(sp.tags) > 32 {
-		sp.tags = nil
+func (d *dapper) putSpan(state *spanState) {
+	if len(state.tags) > 32 {
+		state.tags = nil
 	}
-	if len(sp.logs) > 32 {
-		sp.logs = nil
+	if len(state.logs) > 32 {
+		state.logs = nil
 	}
-	d.pool.Put(sp)
+	d.pool.Put(state)
 }
 
+// getSpan 创建使用对象池中 spanState 的 span.
 func (d *dapper) getSpan() *Span {
-	sp := d.pool.Get().(*Span)
-	sp.dapper = d
-	sp.children = 0
-	sp.tags = sp.tags[:0]
-	sp.logs = sp.logs[:0]
-	return sp
+	state := d.pool.Get().(*spanState)
+	state.mu.Lock()
+	state.children = 0
+	state.tags = state.tags[:0]
+	state.logs = state.logs[:0]
+	state.refType = protogen.SpanRef_CHILD_OF
+	state.links = state.links[:0]
+	gen := state.gen
+	state.mu.Unlock()
+	return &Span{spanState: state, gen: gen, dapper: d}
+}
+
+// lateCall 记录对已结束 span 的调用,strict 模式下输出调用方的堆栈.
+func (d *dapper) lateCall(method string) {
+	atomic.AddInt64(&d.lateCalls, 1)
+	if d.strict {
+		d.stdLog.Printf("%s called on finished span\n%s", method, debug.Stack())
+	}
 }
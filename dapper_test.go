@@ -11,11 +11,11 @@ import (
 )
 
 type mockReport struct {
-	sps []*Span
+	sps []*SpanData
 }
 
-func (m *mockReport) WriteSpan(sp *Span) error {
-	m.sps = append(m.sps, sp)
+func (m *mockReport) WriteSpan(sd *SpanData) error {
+	m.sps = append(m.sps, sd)
 	return nil
 }
 
@@ -46,12 +46,12 @@ func TestDapper(t *testing.T) {
 		sp1.Finish(nil)
 
 		assert.Len(t, report.sps, 3)
-		assert.Equal(t, report.sps[2].context.ParentId, uint64(0))
-		assert.Equal(t, report.sps[0].context.TraceId, report.sps[1].context.TraceId)
-		assert.Equal(t, report.sps[2].context.TraceId, report.sps[1].context.TraceId)
+		assert.Equal(t, report.sps[2].ParentId, uint64(0))
+		assert.Equal(t, report.sps[0].TraceId, report.sps[1].TraceId)
+		assert.Equal(t, report.sps[2].TraceId, report.sps[1].TraceId)
 
-		assert.Equal(t, report.sps[1].context.ParentId, report.sps[2].context.SpanId)
-		assert.Equal(t, report.sps[0].context.ParentId, report.sps[1].context.SpanId)
+		assert.Equal(t, report.sps[1].ParentId, report.sps[2].SpanId)
+		assert.Equal(t, report.sps[0].ParentId, report.sps[1].SpanId)
 	})
 
 	t.Run("test gRPC dapper", func(t *testing.T) {
@@ -71,12 +71,12 @@ func TestDapper(t *testing.T) {
 		sp1.Finish(nil)
 
 		assert.Len(t, report.sps, 3)
-		assert.Equal(t, report.sps[2].context.ParentId, uint64(0))
-		assert.Equal(t, report.sps[0].context.TraceId, report.sps[1].context.TraceId)
-		assert.Equal(t, report.sps[2].context.TraceId, report.sps[1].context.TraceId)
+		assert.Equal(t, report.sps[2].ParentId, uint64(0))
+		assert.Equal(t, report.sps[0].TraceId, report.sps[1].TraceId)
+		assert.Equal(t, report.sps[2].TraceId, report.sps[1].TraceId)
 
-		assert.Equal(t, report.sps[1].context.ParentId, report.sps[2].context.SpanId)
-		assert.Equal(t, report.sps[0].context.ParentId, report.sps[1].context.SpanId)
+		assert.Equal(t, report.sps[1].ParentId, report.sps[2].SpanId)
+		assert.Equal(t, report.sps[0].ParentId, report.sps[1].SpanId)
 	})
 	t.Run("test normal", func(t *testing.T) {
 		report := &mockReport{}
@@ -101,12 +101,12 @@ func TestDapper(t *testing.T) {
 		sp1.Finish(nil)
 
 		assert.Len(t, report.sps, 3)
-		assert.Equal(t, report.sps[2].context.ParentId, uint64(0))
-		assert.Equal(t, report.sps[0].context.TraceId, report.sps[1].context.TraceId)
-		assert.Equal(t, report.sps[2].context.TraceId, report.sps[1].context.TraceId)
+		assert.Equal(t, report.sps[2].ParentId, uint64(0))
+		assert.Equal(t, report.sps[0].TraceId, report.sps[1].TraceId)
+		assert.Equal(t, report.sps[2].TraceId, report.sps[1].TraceId)
 
-		assert.Equal(t, report.sps[1].context.ParentId, report.sps[2].context.SpanId)
-		assert.Equal(t, report.sps[0].context.ParentId, report.sps[1].context.SpanId)
+		assert.Equal(t, report.sps[1].ParentId, report.sps[2].SpanId)
+		assert.Equal(t, report.sps[0].ParentId, report.sps[1].SpanId)
 	})
 }
 
@@ -32,4 +32,5 @@ var (
 
 	errEmptyTracerString   = errors.New("trace: cannot convert empty string to span context")
 	errInvalidTracerString = errors.New("trace: string does not match span context string format")
+	errSpanFinished        = errors.New("trace: span already finished")
 )
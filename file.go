@@ -0,0 +1,242 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	fileFormatJSON     = "json"
+	fileFormatSpanJSON = "span_json"
+	fileFormatProtobuf = "protobuf"
+
+	defaultMaxFileSize = 1024 * 1024 * 100
+	// 轮转后文件名中的时间格式,保证按文件名排序即按时间排序
+	fileBackupTimeFormat = "20060102T150405.000000"
+)
+
+// newFileReport 创建将 span 追加写入本地文件的上报器.
+// json 格式每行一个 zipkin v2 span;span_json 格式每行一个 span,可以使用 UnmarshalSpanJSON 读取;
+// protobuf 格式每个 span 为一帧,每个文件开头以及新的服务出现时写入 Resource 帧,可以使用 SpanReader 读取.
+// 不支持的格式使用默认格式;文件无法打开时每次写入前重试,期间的 span 计入 Failed.
+func newFileReport(cfg *Config) *fileReport {
+	format := cfg.Format
+	if format != fileFormatJSON && format != fileFormatSpanJSON && format != fileFormatProtobuf {
+		if format != "" {
+			errorf("trace: unsupported file format %q, use %s", format, fileFormatJSON)
+		}
+		format = fileFormatJSON
+	}
+	version := cfg.ProtocolVersion
+	if version == 0 {
+		version = protoVersion1
+	}
+	maxSize := cfg.MaxFileSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxFileSize
+	}
+	report := &fileReport{
+		path:       cfg.Addr,
+		format:     format,
+		version:    version,
+		maxSize:    maxSize,
+		rotateAge:  time.Duration(cfg.RotateInterval),
+		maxBackups: cfg.MaxBackups,
+	}
+	if format == fileFormatProtobuf {
+		report.resources = newResourceFrames(version)
+	}
+	if err := report.open(); err != nil {
+		errorf("open trace file error: %s, retry on next write", err)
+	}
+	batchSize, interval := batchConfig(cfg)
+	report.batch = newBatcher(batchSize, maxPackageSize, interval, report.flush)
+	go report.batch.daemon()
+	return report
+}
+
+type fileReport struct {
+	path    string
+	format  string
+	version int32
+
+	// maxSize 单个文件的大小上限
+	maxSize int64
+	// rotateAge 文件按时间轮转的间隔,为 0 时不按时间轮转
+	rotateAge time.Duration
+	// maxBackups 保留的轮转文件数量,为 0 时全部保留
+	maxBackups int
+	// resources protobuf 格式的 Resource 帧,announced 为当前文件中已经写入的数量
+	resources *resourceFrames
+	announced int
+
+	// file 为空时文件尚未成功打开
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	batch    *batcher
+}
+
+func (f *fileReport) WriteSpan(sd *SpanData) error {
+	if f.format != fileFormatProtobuf {
+		var (
+			data []byte
+			err  error
+		)
+		if f.format == fileFormatSpanJSON {
+			data, err = json.Marshal(sd)
+		} else {
+			data, err = json.Marshal(toZipkinSpan(sd))
+		}
+		if err != nil {
+			atomic.AddInt64(&f.batch.stats.marshalErrors, 1)
+			return err
+		}
+		return f.batch.write(append(data, '\n'))
+	}
+	f.resources.add(sd)
+	data, err := marshalFramedSpan(sd, f.version)
+	if err != nil {
+		atomic.AddInt64(&f.batch.stats.marshalErrors, 1)
+		return err
+	}
+	return f.batch.write(appendFrame(make([]byte, 0, frameSize(len(data))), f.version, 0, data))
+}
+
+func (f *fileReport) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return f.Shutdown(ctx)
+}
+
+// Flush 将队列中的 span 写入文件,等待写入完成或者 ctx 结束.
+func (f *fileReport) Flush(ctx context.Context) error {
+	return f.batch.flushContext(ctx)
+}
+
+// Shutdown 停止接收 span,等待队列中的 span 写入文件或者 ctx 结束后关闭文件.
+func (f *fileReport) Shutdown(ctx context.Context) error {
+	// 超时时 daemon 同样已经退出,可以直接关闭文件
+	err := f.batch.shutdown(ctx)
+	if err == errReportClosed {
+		return err
+	}
+	if f.file != nil {
+		if cerr := f.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Stats 返回上报器的统计数据.
+func (f *fileReport) Stats() ReportStats {
+	return f.batch.Stats()
+}
+
+// flush 写入本地文件,不需要 ctx.
+func (f *fileReport) flush(_ context.Context, batch [][]byte) {
+	for i, data := range batch {
+		if f.file != nil && f.shouldRotate(len(data)) {
+			if err := f.rotate(); err != nil {
+				errorf("rotate trace file error: %s", err)
+			}
+		}
+		if f.file == nil {
+			if err := f.open(); err != nil {
+				f.batch.stats.result(len(batch)-i, err)
+				errorf("open trace file error: %s", err)
+				return
+			}
+		}
+		var announced int
+		if f.resources != nil {
+			var frames []byte
+			if frames, announced = f.resources.appendFrames(nil, f.announced); len(frames) > 0 {
+				data = append(frames, data...)
+			}
+		}
+		n, err := f.file.Write(data)
+		f.size += int64(n)
+		f.batch.stats.result(1, err)
+		if err != nil {
+			errorf("write to trace file error: %s", err)
+			continue
+		}
+		f.announced = announced
+	}
+}
+
+func (f *fileReport) shouldRotate(n int) bool {
+	if f.size > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+	return f.rotateAge > 0 && time.Since(f.openedAt) >= f.rotateAge
+}
+
+func (f *fileReport) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	f.announced = 0
+	return nil
+}
+
+// rotate 将当前文件重命名为带时间后缀的备份文件,并打开新的文件.
+func (f *fileReport) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+	backup := f.path + "." + time.Now().Format(fileBackupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		// 重命名失败时继续写入原文件
+		if oerr := f.open(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+// prune 删除超过 maxBackups 数量的最旧的备份文件.
+func (f *fileReport) prune() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(f.path + ".[0-9]*")
+	if err != nil {
+		return err
+	}
+	if len(backups) <= f.maxBackups {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-f.maxBackups] {
+		if err := os.Remove(backup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
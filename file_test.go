@@ -0,0 +1,144 @@
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileReport(t *testing.T) {
+	t.Run("test span json", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "trace", "spans.log")
+		report := newReporter(&Config{Network: "file", Addr: path, Format: fileFormatSpanJSON})
+		t1 := NewTracer("service1", nil, report, true)
+		sp1 := t1.New("opt_server")
+		sp1.Fork("", "opt_client").Finish(nil)
+		sp1.Finish(nil)
+		if err := report.Close(); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		var names []string
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			sd, err := UnmarshalSpanJSON(scanner.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, sd.OperationName)
+		}
+		assert.Equal(t, []string{"opt_client", "opt_server"}, names)
+	})
+	t.Run("test json", func(t *testing.T) {
+		// 默认格式为 zipkin v2 span
+		path := filepath.Join(t.TempDir(), "spans.log")
+		report := newReporter(&Config{Network: "file", Addr: path})
+		t1 := NewTracer("service1", nil, report, true)
+		t1.New("opt_1").Finish(nil)
+		if err := report.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sp zipkinSpan
+		if err := json.Unmarshal(data, &sp); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "opt_1", sp.Name)
+	})
+	t.Run("test protobuf", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "spans.bin")
+		report := newFileReport(&Config{Addr: path, Format: fileFormatProtobuf})
+		t1 := NewTracer("service1", nil, report, true)
+		t1.New("opt_1").Finish(nil)
+		t1.New("opt_2").Finish(nil)
+		if err := report.Close(); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		r := bufio.NewReader(file)
+		for _, name := range []string{"opt_1", "opt_2"} {
+			sp, err := ReadSpan(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, name, sp.OperationName)
+		}
+		_, err = ReadSpan(r)
+		assert.Equal(t, io.EOF, err)
+	})
+	t.Run("test unsupported format", func(t *testing.T) {
+		report := newFileReport(&Config{Addr: filepath.Join(t.TempDir(), "spans"), Format: "xml"})
+		defer report.Close()
+		assert.Equal(t, fileFormatJSON, report.format)
+	})
+	t.Run("test open error", func(t *testing.T) {
+		dir := t.TempDir()
+		// 目录位置被文件占用,无法创建
+		blocker := filepath.Join(dir, "blocker")
+		if err := os.WriteFile(blocker, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(blocker, "spans.log")
+		report := newReporter(&Config{Network: "file", Addr: path}).(*fileReport)
+		t1 := NewTracer("service1", nil, report, true)
+		t1.New("opt_1").Finish(nil)
+		assert.Nil(t, report.Flush(context.Background()))
+		assert.Equal(t, int64(1), report.Stats().Failed)
+		// 恢复后继续写入
+		os.Remove(blocker)
+		t1.New("opt_2").Finish(nil)
+		assert.Nil(t, report.Close())
+		assert.Equal(t, int64(1), report.Stats().Sent)
+		_, err := os.Stat(path)
+		assert.Nil(t, err)
+	})
+}
+
+func TestFileReportRotate(t *testing.T) {
+	t.Run("test rotate by size", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "spans.log")
+		report := newFileReport(&Config{Addr: path, MaxFileSize: 10, MaxBackups: 2})
+		for i := 0; i < 5; i++ {
+			report.flush(context.Background(), [][]byte{[]byte("0123456789")})
+			// 保证备份文件名中的时间不同
+			time.Sleep(time.Millisecond)
+		}
+		report.Close()
+		backups, _ := filepath.Glob(path + ".*")
+		assert.Len(t, backups, 2)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, "0123456789", string(data))
+	})
+	t.Run("test rotate by time", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "spans.log")
+		report := newFileReport(&Config{Addr: path, RotateInterval: utils.Duration(time.Millisecond)})
+		report.flush(context.Background(), [][]byte{[]byte("a")})
+		time.Sleep(2 * time.Millisecond)
+		report.flush(context.Background(), [][]byte{[]byte("b")})
+		report.Close()
+		backups, _ := filepath.Glob(path + ".*")
+		assert.Len(t, backups, 1)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, "b", string(data))
+	})
+}
@@ -0,0 +1,217 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	errs "errors"
+	"io"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	protogen "github.com/aluka-7/trace/proto"
+)
+
+// 帧格式,用于 tcp/unix 等流式网络以及批量上报:
+//
+//	+---------+-------+-----------------+---------+
+//	| version | flags | uvarint(length) | payload |
+//	+---------+-------+-----------------+---------+
+//
+// version 为 Config.ProtocolVersion,接收方据此选择 payload 的解码方式;
+// flags 为标记位,设置了压缩标记时 payload 为压缩后的一组帧(批量包),见 compress.go;
+// 设置了 Resource 标记时 payload 为描述之后 span 所属进程的 Resource,见 resourceFrames.
+// 压缩标记与 version 位于同一个帧头中,而不是占用新的协议版本号:version 只描述 span 的编码,
+// v1 与 v2 都可以压缩,接收方读取帧头即可识别压缩的帧.
+const (
+	frameHeaderSize = 2
+	// frameFlagGzip payload 使用 gzip 压缩
+	frameFlagGzip byte = 1 << 0
+	// frameFlagDeflate payload 使用 deflate 压缩
+	frameFlagDeflate byte = 1 << 1
+	// frameFlagCompressed 全部压缩标记
+	frameFlagCompressed = frameFlagGzip | frameFlagDeflate
+	// frameFlagResource payload 为 Resource,编码为只有 service_name,env 与 tags 的 Span
+	frameFlagResource byte = 1 << 2
+	// 帧长度上限,防止损坏的数据导致分配过大的内存
+	maxFrameSize = 1024 * 1024 * 16
+)
+
+var (
+	errFrameTooLarge   = errs.New("trace: frame too large")
+	errFrameVarint     = errs.New("trace: frame length overflow")
+	errFrameCompressed = errs.New("trace: compressed frame, use SpanReader")
+)
+
+// isStreamNetwork 判断网络是否为没有消息边界的流式网络.
+func isStreamNetwork(network string) bool {
+	return strings.HasPrefix(network, "tcp") || network == "unix"
+}
+
+// frameSize 返回 payload 编码为帧后的字节数.
+func frameSize(n int) int {
+	return frameHeaderSize + uvarintSize(n) + n
+}
+
+// appendFrame 将 payload 编码为一帧并追加到 buf.
+func appendFrame(buf []byte, version int32, flags byte, payload []byte) []byte {
+	buf = append(buf, byte(version), flags)
+	buf = appendUvarint(buf, uint64(len(payload)))
+	return append(buf, payload...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+// readFrame 从 r 读取一帧.
+func readFrame(r io.Reader) (version int32, flags byte, payload []byte, err error) {
+	var header [frameHeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	length, err := readUvarint(r)
+	if err != nil {
+		return 0, 0, nil, noEOF(err)
+	}
+	if length > maxFrameSize {
+		return 0, 0, nil, errFrameTooLarge
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, noEOF(err)
+	}
+	return int32(header[0]), header[1], payload, nil
+}
+
+// readUvarint 逐字节读取 uvarint,避免在 r 上引入额外的缓冲.
+func readUvarint(r io.Reader) (uint64, error) {
+	var (
+		x uint64
+		s uint
+		b [1]byte
+	)
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		if b[0] < 0x80 {
+			return x | uint64(b[0])<<s, nil
+		}
+		x |= uint64(b[0]&0x7f) << s
+		s += 7
+	}
+	return 0, errFrameVarint
+}
+
+// noEOF 帧读取到一半时遇到 EOF 说明数据被截断.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadSpan 从 r 中读取并解码一个帧格式的 span,Resource 帧会被跳过.
+// 数据读完时返回 io.EOF,帧被截断时返回 io.ErrUnexpectedEOF.
+// 遇到压缩的帧时返回错误,读取可能包含压缩帧的数据或者需要 Resource 时使用 SpanReader.
+func ReadSpan(r io.Reader) (*protogen.Span, error) {
+	for {
+		version, flags, payload, err := readFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if flags&frameFlagResource != 0 {
+			continue
+		}
+		if flags&frameFlagCompressed != 0 {
+			return nil, errFrameCompressed
+		}
+		return unmarshalFrame(version, payload)
+	}
+}
+
+// unmarshalFrame 解码未压缩帧中的 span.
+func unmarshalFrame(version int32, payload []byte) (*protogen.Span, error) {
+	if version != protoVersion1 && version != protoVersion2 {
+		return nil, errSpanVersion
+	}
+	sp := new(protogen.Span)
+	if err := proto.Unmarshal(payload, sp); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// SpanReader 从帧格式的数据流中逐个读取 span,压缩的帧会被解压为其中的多个 span.
+type SpanReader struct {
+	r io.Reader
+	// pending 当前压缩帧解压后尚未读取的数据
+	pending *bytes.Reader
+	// resources 各服务最近读取到的 Resource
+	resources map[string]*protogen.Span
+}
+
+// NewSpanReader 创建从 r 读取 span 的 SpanReader.
+func NewSpanReader(r io.Reader) *SpanReader {
+	return &SpanReader{r: r, resources: make(map[string]*protogen.Span)}
+}
+
+// Read 读取下一个 span,数据读完时返回 io.EOF,帧被截断时返回 io.ErrUnexpectedEOF.
+// 读取到的 Resource 帧不会返回,使用 Resource 获取.
+func (s *SpanReader) Read() (*protogen.Span, error) {
+	for {
+		if s.pending != nil && s.pending.Len() > 0 {
+			version, flags, payload, err := readFrame(s.pending)
+			if err != nil {
+				return nil, noEOF(err)
+			}
+			if flags&frameFlagCompressed != 0 {
+				// 不支持嵌套压缩
+				return nil, errFrameCompressed
+			}
+			if flags&frameFlagResource != 0 {
+				if err := s.readResource(version, payload); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return unmarshalFrame(version, payload)
+		}
+		version, flags, payload, err := readFrame(s.r)
+		if err != nil {
+			return nil, err
+		}
+		if flags&frameFlagResource != 0 {
+			if err := s.readResource(version, payload); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if flags&frameFlagCompressed == 0 {
+			return unmarshalFrame(version, payload)
+		}
+		data, err := decompress(flags, payload)
+		if err != nil {
+			return nil, err
+		}
+		s.pending = bytes.NewReader(data)
+	}
+}
+
+func (s *SpanReader) readResource(version int32, payload []byte) error {
+	resource, err := unmarshalFrame(version, payload)
+	if err != nil {
+		return err
+	}
+	s.resources[resource.ServiceName] = resource
+	return nil
+}
+
+// Resource 返回已读取的数据中服务最近的 Resource,没有时返回 nil.
+// Tags 包含进程属性以及 NewTracer 传入的 tag,帧格式中的 span 不再重复写入这些 tag.
+func (s *SpanReader) Resource(service string) *protogen.Span {
+	return s.resources[service]
+}
@@ -0,0 +1,61 @@
+package trace
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrame(t *testing.T) {
+	t.Run("test read frame", func(t *testing.T) {
+		buf := appendFrame(nil, protoVersion1, 0, []byte("hello"))
+		buf = appendFrame(buf, protoVersion1, 0, make([]byte, 300))
+		assert.Len(t, buf, frameSize(5)+frameSize(300))
+		r := bytes.NewReader(buf)
+		version, flags, payload, err := readFrame(r)
+		assert.Nil(t, err)
+		assert.Equal(t, protoVersion1, version)
+		assert.Equal(t, byte(0), flags)
+		assert.Equal(t, []byte("hello"), payload)
+		_, _, payload, err = readFrame(r)
+		assert.Nil(t, err)
+		assert.Len(t, payload, 300)
+		_, _, _, err = readFrame(r)
+		assert.Equal(t, io.EOF, err)
+	})
+	t.Run("test truncated frame", func(t *testing.T) {
+		buf := appendFrame(nil, protoVersion1, 0, []byte("hello"))
+		_, _, _, err := readFrame(bytes.NewReader(buf[:len(buf)-1]))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+}
+
+func TestReadSpan(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cancel, err := newServer(buf, "tcp", "127.0.0.1:6079")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// v2 在流式网络中使用帧格式
+	report := newReport("tcp", "127.0.0.1:6079", 0, protoVersion2)
+	t1 := NewTracer("service1", nil, report, true)
+	t1.New("opt_1").Finish(nil)
+	t1.New("opt_2").Finish(nil)
+	if err := report.Close(); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	for _, name := range []string{"opt_1", "opt_2"} {
+		sp, err := ReadSpan(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, protoVersion2, sp.Version)
+		assert.Equal(t, "service1", sp.ServiceName)
+		assert.Equal(t, name, sp.OperationName)
+	}
+	_, err = ReadSpan(buf)
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,251 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultJaegerAgentAddr = "127.0.0.1:6831"
+	// jaeger-agent 默认的 UDP 包大小上限
+	jaegerMaxPacketSize = 65000
+	// 消息头,Batch 等结构在每个包中的最大开销(不含 Process)
+	jaegerPacketOverhead = 64
+)
+
+// jaeger tag 值类型,见 jaeger-idl thrift/jaeger.thrift
+const (
+	jaegerTagString int32 = 0
+	jaegerTagDouble int32 = 1
+	jaegerTagBool   int32 = 2
+	jaegerTagLong   int32 = 3
+)
+
+// newJaegerReport 创建通过 UDP 以 thrift compact 协议向 jaeger-agent 上报的上报器.
+func newJaegerReport(cfg *Config) *jaegerReport {
+	timeout := configTimeout(cfg, defaultWriteTimeout)
+	batchSize, interval := batchConfig(cfg)
+	address := cfg.Addr
+	if address == "" {
+		address = defaultJaegerAgentAddr
+	}
+	report := &jaegerReport{address: address, timeout: timeout, processes: newResourceCache(encodeJaegerProcess)}
+	report.batch = newBatcher(batchSize, jaegerMaxPacketSize, interval, report.flush)
+	go report.batch.daemon()
+	return report
+}
+
+type jaegerReport struct {
+	address string
+	timeout time.Duration
+	conn    net.Conn
+	seq     int32
+	batch   *batcher
+	// processes 各服务编码后的 Process,每个包只写入一次
+	processes *resourceCache
+}
+
+func (j *jaegerReport) WriteSpan(sd *SpanData) error {
+	process := j.processes.get(sd)
+	maxSize := jaegerMaxPacketSize - jaegerPacketOverhead - len(process)
+	span, truncated, _ := truncateSpan(sd, maxSize, func(sd *SpanData) ([]byte, error) {
+		return encodeJaegerSpan(sd), nil
+	})
+	if truncated {
+		atomic.AddInt64(&j.batch.stats.truncated, 1)
+	}
+	if size := jaegerPacketOverhead + len(process) + len(span); size > jaegerMaxPacketSize {
+		atomic.AddInt64(&j.batch.stats.droppedTooLarge, 1)
+		return fmt.Errorf("package too large length %d > %d", size, jaegerMaxPacketSize)
+	}
+	return j.batch.write(appendServiceItem(sd.ServiceName, span))
+}
+
+func (j *jaegerReport) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return j.Shutdown(ctx)
+}
+
+// Flush 立即发送队列中的 span,等待发送完成或者 ctx 结束.
+func (j *jaegerReport) Flush(ctx context.Context) error {
+	return j.batch.flushContext(ctx)
+}
+
+// Shutdown 停止接收 span,等待队列中的 span 发送完成或者 ctx 结束后关闭连接.
+func (j *jaegerReport) Shutdown(ctx context.Context) error {
+	err := j.batch.shutdown(ctx)
+	if err == errReportClosed {
+		return err
+	}
+	if j.conn != nil {
+		if cerr := j.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Stats 返回上报器的统计数据.
+func (j *jaegerReport) Stats() ReportStats {
+	return j.batch.Stats()
+}
+
+// flush 写入 UDP 不会阻塞,不需要 ctx.
+func (j *jaegerReport) flush(_ context.Context, batch [][]byte) {
+	services, groups := groupByService(batch)
+	for _, service := range services {
+		spans := groups[service]
+		process := j.processes.load(service)
+		for len(spans) > 0 {
+			i, size := 0, jaegerPacketOverhead+len(process)
+			for ; i < len(spans) && size+len(spans[i]) <= jaegerMaxPacketSize; i++ {
+				size += len(spans[i])
+			}
+			j.batch.stats.result(i, j.send(j.packet(process, spans[:i])))
+			spans = spans[i:]
+		}
+	}
+}
+
+// packet 编码 Agent.emitBatch 调用,process 为 encodeJaegerProcess 编码的 Process.
+func (j *jaegerReport) packet(process []byte, spans [][]byte) []byte {
+	j.seq++
+	w := &compactWriter{}
+	w.messageBegin("emitBatch", thriftOneway, j.seq)
+	w.structBegin()
+	w.fieldBegin(compactStruct, 1)
+	// Batch
+	w.structBegin()
+	w.fieldBegin(compactStruct, 1)
+	w.buf = append(w.buf, process...)
+	w.fieldBegin(compactList, 2)
+	w.listBegin(compactStruct, len(spans))
+	for _, span := range spans {
+		w.buf = append(w.buf, span...)
+	}
+	w.structEnd()
+	w.structEnd()
+	return w.buf
+}
+
+func (j *jaegerReport) send(data []byte) error {
+	if j.conn == nil {
+		conn, err := net.DialTimeout("udp", j.address, j.timeout)
+		if err != nil {
+			errorf("connect to jaeger agent error: %s", err)
+			return err
+		}
+		j.conn = conn
+		atomic.AddInt64(&j.batch.stats.reconnects, 1)
+	}
+	j.conn.SetWriteDeadline(time.Now().Add(j.timeout))
+	if _, err := j.conn.Write(data); err != nil {
+		errorf("write to jaeger agent error: %s, close connect", err)
+		j.conn.Close()
+		j.conn = nil
+		return err
+	}
+	return nil
+}
+
+// encodeJaegerProcess 将服务名与 Resource 的属性编码为 jaeger.thrift 中的 Process 结构.
+func encodeJaegerProcess(service string, r *Resource) []byte {
+	w := &compactWriter{}
+	w.structBegin()
+	w.stringField(1, service)
+	if r != nil && len(r.Tags) > 0 {
+		w.fieldBegin(compactList, 2)
+		w.listBegin(compactStruct, len(r.Tags))
+		for _, tag := range r.Tags {
+			writeJaegerTag(w, tag.Key, tag.Value)
+		}
+	}
+	w.structEnd()
+	return w.buf
+}
+
+// encodeJaegerSpan 将 span 编码为 jaeger.thrift 中的 Span 结构.
+func encodeJaegerSpan(sd *SpanData) []byte {
+	w := &compactWriter{}
+	w.structBegin()
+	w.i64Field(1, int64(sd.TraceId))
+	w.i64Field(2, 0)
+	w.i64Field(3, int64(sd.SpanId))
+	w.i64Field(4, int64(sd.ParentId))
+	w.stringField(5, sd.OperationName)
+	if len(sd.References) > 0 {
+		w.fieldBegin(compactList, 6)
+		w.listBegin(compactStruct, len(sd.References))
+		for _, ref := range sd.References {
+			// SpanRefType 的取值与 RefType 相同
+			w.structBegin()
+			w.i32Field(1, int32(ref.Type))
+			w.i64Field(2, int64(ref.TraceId))
+			w.i64Field(3, 0)
+			w.i64Field(4, int64(ref.SpanId))
+			w.structEnd()
+		}
+	}
+	w.i32Field(7, int32(sd.Flags))
+	w.i64Field(8, sd.StartTime.UnixNano()/int64(time.Microsecond))
+	w.i64Field(9, int64(sd.Duration/time.Microsecond))
+	if len(sd.Tags) > 0 {
+		w.fieldBegin(compactList, 10)
+		w.listBegin(compactStruct, len(sd.Tags))
+		for _, tag := range sd.Tags {
+			writeJaegerTag(w, tag.Key, tag.Value)
+		}
+	}
+	if len(sd.Logs) > 0 {
+		w.fieldBegin(compactList, 11)
+		w.listBegin(compactStruct, len(sd.Logs))
+		for _, log := range sd.Logs {
+			w.structBegin()
+			w.i64Field(1, log.Timestamp.UnixNano()/int64(time.Microsecond))
+			w.fieldBegin(compactList, 2)
+			w.listBegin(compactStruct, len(log.Fields))
+			for _, field := range log.Fields {
+				writeJaegerTag(w, field.Key, logValueString(field.Value))
+			}
+			w.structEnd()
+		}
+	}
+	w.structEnd()
+	return w.buf
+}
+
+func writeJaegerTag(w *compactWriter, key string, value interface{}) {
+	w.structBegin()
+	w.stringField(1, key)
+	switch v := value.(type) {
+	case string:
+		w.i32Field(2, jaegerTagString)
+		w.stringField(3, v)
+	case int:
+		w.i32Field(2, jaegerTagLong)
+		w.i64Field(6, int64(v))
+	case int32:
+		w.i32Field(2, jaegerTagLong)
+		w.i64Field(6, int64(v))
+	case int64:
+		w.i32Field(2, jaegerTagLong)
+		w.i64Field(6, v)
+	case bool:
+		w.i32Field(2, jaegerTagBool)
+		w.boolField(5, v)
+	case float32:
+		w.i32Field(2, jaegerTagDouble)
+		w.doubleField(4, float64(v))
+	case float64:
+		w.i32Field(2, jaegerTagDouble)
+		w.doubleField(4, v)
+	default:
+		w.i32Field(2, jaegerTagString)
+		w.stringField(3, fmt.Sprintf("%v", v))
+	}
+	w.structEnd()
+}
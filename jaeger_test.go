@@ -0,0 +1,163 @@
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// compactReader 测试用的 thrift compact 协议解码器,结构体解码为 map[int16]interface{}.
+type compactReader struct {
+	buf []byte
+}
+
+func (r *compactReader) byte() byte {
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *compactReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *compactReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *compactReader) string() string {
+	n := r.varint()
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+func (r *compactReader) value(typeID byte) interface{} {
+	switch typeID {
+	case compactBoolTrue:
+		return true
+	case compactBoolFalse:
+		return false
+	case compactI32, compactI64:
+		return r.zigzag()
+	case compactDouble:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.buf))
+		r.buf = r.buf[8:]
+		return v
+	case compactBinary:
+		return r.string()
+	case compactList:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(header & 0x0F)
+		}
+		return list
+	case compactStruct:
+		fields := make(map[int16]interface{})
+		var lastID int16
+		for {
+			header := r.byte()
+			if header == compactStop {
+				return fields
+			}
+			id := lastID + int16(header>>4)
+			if header>>4 == 0 {
+				id = int16(r.zigzag())
+			}
+			fields[id] = r.value(header & 0x0F)
+			lastID = id
+		}
+	}
+	panic(fmt.Sprintf("unsupported type %d", typeID))
+}
+
+func TestJaegerReport(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	report := newReporter(&Config{Network: "jaeger", Addr: conn.LocalAddr().String()})
+	t1 := NewTracer("service1", []Tag{TagString("ip", "10.0.0.1")}, report, true)
+	sp1 := t1.New("opt_server")
+	sp2 := sp1.Fork("", "opt_client").SetTag(TagInt(TagPeerPort, 6379), TagFloat64("ratio", 0.5))
+	sp2.SetLog(Log(LogEvent, "timeout"))
+	sp2.Finish(nil)
+	sp1.Finish(nil)
+	if err := report.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, jaegerMaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &compactReader{buf: p[:n]}
+	assert.Equal(t, compactProtocolID, r.byte())
+	assert.Equal(t, compactVersion|thriftOneway<<5, r.byte())
+	assert.Equal(t, uint64(1), r.varint())
+	assert.Equal(t, "emitBatch", r.string())
+	args := r.value(compactStruct).(map[int16]interface{})
+	assert.Empty(t, r.buf)
+
+	batch := args[1].(map[int16]interface{})
+	process := batch[1].(map[int16]interface{})
+	assert.Equal(t, "service1", process[1])
+	processTags := make(map[string]interface{})
+	for _, tag := range process[2].([]interface{}) {
+		tag := tag.(map[int16]interface{})
+		processTags[tag[1].(string)] = tag
+	}
+	assert.Contains(t, processTags, "ip")
+	assert.Contains(t, processTags, ResourceProcessPID)
+	assert.Contains(t, processTags, ResourceRuntimeVersion)
+	spans := batch[2].([]interface{})
+	assert.Len(t, spans, 2)
+	client, server := spans[0].(map[int16]interface{}), spans[1].(map[int16]interface{})
+	assert.Equal(t, "opt_server", server[5])
+	assert.Equal(t, "opt_client", client[5])
+	assert.Equal(t, server[1], client[1])
+	assert.Equal(t, server[3], client[4])
+	assert.Equal(t, int64(flagSampled), server[7])
+
+	tags := make(map[string]map[int16]interface{})
+	for _, tag := range client[10].([]interface{}) {
+		tag := tag.(map[int16]interface{})
+		tags[tag[1].(string)] = tag
+	}
+	assert.NotContains(t, tags, "ip")
+	assert.Equal(t, "client", tags[TagSpanKind][3])
+	assert.Equal(t, int64(jaegerTagLong), tags[TagPeerPort][2])
+	assert.Equal(t, int64(6379), tags[TagPeerPort][6])
+	assert.Equal(t, 0.5, tags["ratio"][4])
+
+	logs := client[11].([]interface{})
+	assert.Len(t, logs, 1)
+	fields := logs[0].(map[int16]interface{})[2].([]interface{})
+	assert.Equal(t, "timeout", fields[0].(map[int16]interface{})[3])
+}
+
+func TestJaegerPacketSize(t *testing.T) {
+	report := newJaegerReport(&Config{Addr: "127.0.0.1:0"})
+	defer report.Close()
+	span := make([]byte, 1024)
+	process := encodeJaegerProcess("service1", newResource("service1", "uat", nil))
+	packet := report.packet(process, [][]byte{span, span, span})
+	assert.True(t, len(packet) <= jaegerPacketOverhead+len(process)+3*len(span))
+}
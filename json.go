@@ -0,0 +1,259 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// span 的 JSON 格式,字段名与类型保持稳定:
+//
+//	{
+//	  "trace_id": "0123456789abcdef", "span_id": "...", "parent_id": "...",
+//	  "service": "...", "operation": "...", "env": "...",
+//	  "start_time": "2006-01-02T15:04:05.999999999Z07:00", "duration_ns": 1500000,
+//	  "references": [{"type": "child_of", "trace_id": "...", "span_id": "..."}],
+//	  "tags": [{"key": "...", "type": "string|int|bool|float", "value": ...}],
+//	  "logs": [{"timestamp": "...", "fields": [{"key": "...", "type": "...", "value": ...}]}]
+//	}
+//
+// ID 为 16 位十六进制字符串;int 类型的值为 JSON 数字,不能表示的浮点数(NaN,±Inf)为字符串.
+type spanJSON struct {
+	Version             int32         `json:"version,omitempty"`
+	TraceId             string        `json:"trace_id"`
+	SpanId              string        `json:"span_id"`
+	ParentId            string        `json:"parent_id,omitempty"`
+	Service             string        `json:"service"`
+	Operation           string        `json:"operation"`
+	Env                 string        `json:"env,omitempty"`
+	SamplingProbability float32       `json:"sampling_probability,omitempty"`
+	StartTime           time.Time     `json:"start_time"`
+	Duration            int64         `json:"duration_ns"`
+	References          []refJSON     `json:"references,omitempty"`
+	Tags                []valueJSON   `json:"tags,omitempty"`
+	Logs                []logDataJSON `json:"logs,omitempty"`
+}
+
+type refJSON struct {
+	Type    string `json:"type"`
+	TraceId string `json:"trace_id"`
+	SpanId  string `json:"span_id"`
+}
+
+type logDataJSON struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Fields    []valueJSON `json:"fields"`
+}
+
+type valueJSON struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+var refTypeNames = map[RefType]string{RefChildOf: "child_of", RefFollowsFrom: "follows_from"}
+
+// MarshalJSON 按稳定的 JSON 格式编码 span,span 结束后返回错误,此时应使用上报器收到的 SpanData.
+func (s *Span) MarshalJSON() ([]byte, error) {
+	if !s.lock() {
+		return nil, errSpanFinished
+	}
+	sd := newSpanData(s)
+	s.mu.Unlock()
+	return json.Marshal(sd)
+}
+
+// MarshalJSON 按稳定的 JSON 格式编码 span,见 json.go.
+func (sd *SpanData) MarshalJSON() ([]byte, error) {
+	sj := spanJSON{
+		Version:             sd.Version,
+		TraceId:             formatID(sd.TraceId),
+		SpanId:              formatID(sd.SpanId),
+		Service:             sd.ServiceName,
+		Operation:           sd.OperationName,
+		Env:                 sd.Env,
+		SamplingProbability: sd.SamplingProbability,
+		StartTime:           sd.StartTime.UTC(),
+		Duration:            int64(sd.Duration),
+		Tags:                toValuesJSON(sd.spanTags()),
+	}
+	if sd.ParentId != 0 {
+		sj.ParentId = formatID(sd.ParentId)
+	}
+	for _, ref := range sd.References {
+		sj.References = append(sj.References, refJSON{Type: refTypeNames[ref.Type], TraceId: formatID(ref.TraceId), SpanId: formatID(ref.SpanId)})
+	}
+	for _, log := range sd.Logs {
+		sj.Logs = append(sj.Logs, logDataJSON{Timestamp: log.Timestamp.UTC(), Fields: toValuesJSON(log.Fields)})
+	}
+	return json.Marshal(sj)
+}
+
+// UnmarshalJSON 解码 MarshalJSON 生成的 JSON,tag 与 log 字段的值按 type 还原为 Go 的值.
+func (sd *SpanData) UnmarshalJSON(data []byte) error {
+	var sj spanJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&sj); err != nil {
+		return err
+	}
+	var err error
+	out := SpanData{
+		Version:             sj.Version,
+		ServiceName:         sj.Service,
+		OperationName:       sj.Operation,
+		Env:                 sj.Env,
+		SamplingProbability: sj.SamplingProbability,
+		StartTime:           sj.StartTime,
+		Duration:            time.Duration(sj.Duration),
+	}
+	if out.TraceId, err = parseID(sj.TraceId); err != nil {
+		return err
+	}
+	if out.SpanId, err = parseID(sj.SpanId); err != nil {
+		return err
+	}
+	if sj.ParentId != "" {
+		if out.ParentId, err = parseID(sj.ParentId); err != nil {
+			return err
+		}
+	}
+	for _, rj := range sj.References {
+		ref := SpanRef{Type: RefChildOf}
+		if rj.Type == refTypeNames[RefFollowsFrom] {
+			ref.Type = RefFollowsFrom
+		}
+		if ref.TraceId, err = parseID(rj.TraceId); err != nil {
+			return err
+		}
+		if ref.SpanId, err = parseID(rj.SpanId); err != nil {
+			return err
+		}
+		out.References = append(out.References, ref)
+	}
+	if out.Tags, err = fromValuesJSON(sj.Tags); err != nil {
+		return err
+	}
+	for _, lj := range sj.Logs {
+		fields, err := fromValuesJSON(lj.Fields)
+		if err != nil {
+			return err
+		}
+		out.Logs = append(out.Logs, LogData{Timestamp: lj.Timestamp, Fields: fields})
+	}
+	*sd = out
+	return nil
+}
+
+// UnmarshalSpanJSON 解码 JSON 格式的 span,例如 file 上报器以 span_json 格式写入的每一行.
+func UnmarshalSpanJSON(data []byte) (*SpanData, error) {
+	sd := new(SpanData)
+	if err := json.Unmarshal(data, sd); err != nil {
+		return nil, err
+	}
+	return sd, nil
+}
+
+func formatID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+func parseID(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+func toValuesJSON(tags []Tag) []valueJSON {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := make([]valueJSON, len(tags))
+	for i, tag := range tags {
+		v := valueJSON{Key: tag.Key, Value: normalizeValue(tag.Value)}
+		switch value := v.Value.(type) {
+		case string:
+			v.Type = "string"
+		case int64:
+			v.Type = "int"
+		case bool:
+			v.Type = "bool"
+		case float64:
+			v.Type = "float"
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				v.Value = strconv.FormatFloat(value, 'g', -1, 64)
+			}
+		}
+		values[i] = v
+	}
+	return values
+}
+
+func fromValuesJSON(values []valueJSON) ([]Tag, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	tags := make([]Tag, len(values))
+	for i, v := range values {
+		var (
+			value interface{}
+			err   error
+		)
+		switch v.Type {
+		case "string":
+			value, err = jsonString(v.Value)
+		case "int":
+			if n, ok := v.Value.(json.Number); ok {
+				value, err = n.Int64()
+			} else {
+				err = errTagValue
+			}
+		case "bool":
+			if b, ok := v.Value.(bool); ok {
+				value = b
+			} else {
+				err = errTagValue
+			}
+		case "float":
+			switch f := v.Value.(type) {
+			case json.Number:
+				value, err = f.Float64()
+			case string:
+				value, err = strconv.ParseFloat(f, 64)
+			default:
+				err = errTagValue
+			}
+		default:
+			err = errTagValue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errTagValue, v.Key)
+		}
+		tags[i] = Tag{Key: v.Key, Value: value}
+	}
+	return tags, nil
+}
+
+func jsonString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", errTagValue
+}
+
+// normalizeValue 将 tag 的值转换为 SpanData 中使用的 string,int64,bool 或者 float64.
+func normalizeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case string, int64, bool, float64:
+		return value
+	case int:
+		return int64(value)
+	case int32:
+		return int64(value)
+	case float32:
+		return float64(value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
@@ -0,0 +1,92 @@
+package trace
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpanJSON(t *testing.T) {
+	t1 := NewTracer("service1", nil, &mockReport{}, true, WithEnv("uat"))
+	sp1 := t1.New("opt_server").(*Span)
+	sp2 := sp1.Follow("", "opt_producer").(*Span)
+	sp2.SetTag(TagString("str", "hello"), TagInt64("int", math.MaxInt64), TagBool("bool", true), TagFloat64("float", 3.14159), TagInt("small", 7))
+	sp2.SetLog(Log("event", "retry"), LogInt64("attempt", 3))
+	sp2.duration = 1500 * time.Millisecond
+
+	t.Run("test format", func(t *testing.T) {
+		data, err := json.Marshal(sp2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, m["trace_id"], 16)
+		assert.Equal(t, formatID(sp1.context.SpanId), m["parent_id"])
+		assert.Equal(t, "uat", m["env"])
+		assert.Equal(t, float64(1500*time.Millisecond), m["duration_ns"])
+		assert.True(t, strings.HasSuffix(m["start_time"].(string), "Z"))
+		assert.Equal(t, "follows_from", m["references"].([]interface{})[0].(map[string]interface{})["type"])
+		assert.Equal(t, map[string]interface{}{"key": "bool", "type": "bool", "value": true}, m["tags"].([]interface{})[4])
+	})
+	t.Run("test round trip", func(t *testing.T) {
+		data, err := json.Marshal(sp2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := UnmarshalSpanJSON(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "service1", sd.ServiceName)
+		assert.Equal(t, "opt_producer", sd.OperationName)
+		assert.Equal(t, sp2.context.TraceId, sd.TraceId)
+		assert.Equal(t, sp2.context.SpanId, sd.SpanId)
+		assert.Equal(t, sp1.context.SpanId, sd.ParentId)
+		assert.True(t, sp2.startTime.Equal(sd.StartTime))
+		assert.Equal(t, 1500*time.Millisecond, sd.Duration)
+		assert.Equal(t, []SpanRef{{Type: RefFollowsFrom, TraceId: sp1.context.TraceId, SpanId: sp1.context.SpanId}}, sd.References)
+		assert.Equal(t, []Tag{
+			TagString(TagSpanKind, "client"),
+			TagString(TagSpanKind, "producer"),
+			TagString("str", "hello"),
+			TagInt64("int", math.MaxInt64),
+			TagBool("bool", true),
+			TagFloat64("float", 3.14159),
+			TagInt64("small", 7),
+		}, sd.Tags)
+		assert.Equal(t, []Tag{{Key: "event", Value: "retry"}, {Key: "attempt", Value: int64(3)}}, sd.Logs[0].Fields)
+		assert.Equal(t, sp2.logs[0].timestamp, sd.Logs[0].Timestamp.UnixNano())
+
+		again, err := json.Marshal(sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, string(data), string(again))
+	})
+	t.Run("test special float", func(t *testing.T) {
+		sd := &SpanData{Tags: []Tag{TagFloat64("nan", math.NaN()), TagFloat64("inf", math.Inf(1))}}
+		data, err := json.Marshal(sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := UnmarshalSpanJSON(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, math.IsNaN(out.Tags[0].Value.(float64)))
+		assert.True(t, math.IsInf(out.Tags[1].Value.(float64), 1))
+	})
+	t.Run("test invalid", func(t *testing.T) {
+		_, err := UnmarshalSpanJSON([]byte(`{"trace_id":"xyz","span_id":"1"}`))
+		assert.NotNil(t, err)
+		_, err = UnmarshalSpanJSON([]byte(`{"trace_id":"1","span_id":"1","tags":[{"key":"k","type":"int","value":"1"}]}`))
+		assert.ErrorIs(t, err, errTagValue)
+	})
+}
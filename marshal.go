@@ -14,42 +14,101 @@ import (
 	protogen "github.com/aluka-7/trace/proto"
 )
 
-const protoVersion1 int32 = 1
+const (
+	protoVersion1 int32 = 1
+	// protoVersion2 在 v1 的基础上浮点数 tag 使用 FLOAT 类型,log 字段的值带有类型,见 serializeLogValue.
+	// references 在各个版本中都会写入
+	protoVersion2 int32 = 2
+)
 
 var (
 	errSpanVersion = errs.New("trace: marshal not support version")
 )
 
-func marshalSpan(sp *Span, version int32) ([]byte, error) {
-	if version == protoVersion1 {
-		return marshalSpanV1(sp)
+// marshalSpan 编码 span,NewTracer 传入的 tag 写入每个 span,用于没有 Resource 帧的数据.
+func marshalSpan(sd *SpanData, version int32) ([]byte, error) {
+	return marshalSpanTags(sd, version, sd.spanTags())
+}
+
+// marshalFramedSpan 编码帧格式中的 span,NewTracer 传入的 tag 由 Resource 帧描述,不再写入 span.
+func marshalFramedSpan(sd *SpanData, version int32) ([]byte, error) {
+	return marshalSpanTags(sd, version, sd.Tags)
+}
+
+func marshalSpanTags(sd *SpanData, version int32, tags []Tag) ([]byte, error) {
+	switch version {
+	case protoVersion1:
+		return marshalSpanV1(sd, tags)
+	case protoVersion2:
+		return marshalSpanV2(sd, tags)
 	}
 	return nil, errSpanVersion
 }
 
-func marshalSpanV1(sp *Span) ([]byte, error) {
-	protoSpan := new(protogen.Span)
+func marshalSpanV1(sd *SpanData, tags []Tag) ([]byte, error) {
+	protoSpan := newProtoSpan(sd)
 	protoSpan.Version = protoVersion1
-	protoSpan.ServiceName = sp.dapper.serviceName
-	protoSpan.OperationName = sp.operationName
-	protoSpan.TraceId = sp.context.TraceId
-	protoSpan.SpanId = sp.context.SpanId
-	protoSpan.ParentId = sp.context.ParentId
-	protoSpan.SamplingProbability = sp.context.Probability
+	protoSpan.Tags = make([]*protogen.Tag, len(tags))
+	for i := range tags {
+		protoSpan.Tags[i] = toProtoTag(tags[i])
+	}
+	protoSpan.Logs = make([]*protogen.Log, len(sd.Logs))
+	for i, log := range sd.Logs {
+		fields := make([]*protogen.Field, len(log.Fields))
+		for j, field := range log.Fields {
+			fields[j] = &protogen.Field{Key: field.Key, Value: []byte(logValueString(field.Value))}
+		}
+		protoSpan.Logs[i] = &protogen.Log{Timestamp: log.Timestamp.UnixNano(), Fields: fields}
+	}
+	return proto.Marshal(protoSpan)
+}
+
+func marshalSpanV2(sd *SpanData, tags []Tag) ([]byte, error) {
+	protoSpan := newProtoSpan(sd)
+	protoSpan.Version = protoVersion2
+	protoSpan.Tags = make([]*protogen.Tag, len(tags))
+	for i := range tags {
+		protoSpan.Tags[i] = toProtoTagV2(tags[i])
+	}
+	protoSpan.Logs = make([]*protogen.Log, len(sd.Logs))
+	for i, log := range sd.Logs {
+		fields := make([]*protogen.Field, len(log.Fields))
+		for j, field := range log.Fields {
+			fields[j] = &protogen.Field{Key: field.Key, Value: serializeLogValue(field.Value)}
+		}
+		protoSpan.Logs[i] = &protogen.Log{Timestamp: log.Timestamp.UnixNano(), Fields: fields}
+	}
+	return proto.Marshal(protoSpan)
+}
+
+// newProtoSpan 填充各个版本共有的字段.
+// references 是协议中原有的字段,v1 的接收方同样可以解析,因此各个版本都写入,Follow 与 Link 的引用不会丢失.
+func newProtoSpan(sd *SpanData) *protogen.Span {
+	protoSpan := new(protogen.Span)
+	protoSpan.ServiceName = sd.ServiceName
+	protoSpan.OperationName = sd.OperationName
+	protoSpan.TraceId = sd.TraceId
+	protoSpan.SpanId = sd.SpanId
+	protoSpan.ParentId = sd.ParentId
+	protoSpan.SamplingProbability = sd.SamplingProbability
+	protoSpan.Env = sd.Env
 	protoSpan.StartTime = &timestamp.Timestamp{
-		Seconds: sp.startTime.Unix(),
-		Nanos:   int32(sp.startTime.Nanosecond()),
+		Seconds: sd.StartTime.Unix(),
+		Nanos:   int32(sd.StartTime.Nanosecond()),
 	}
 	protoSpan.Duration = &duration.Duration{
-		Seconds: int64(sp.duration / time.Second),
-		Nanos:   int32(sp.duration % time.Second),
+		Seconds: int64(sd.Duration / time.Second),
+		Nanos:   int32(sd.Duration % time.Second),
 	}
-	protoSpan.Tags = make([]*protogen.Tag, len(sp.tags))
-	for i := range sp.tags {
-		protoSpan.Tags[i] = toProtoTag(sp.tags[i])
+	protoSpan.References = make([]*protogen.SpanRef, len(sd.References))
+	for i, ref := range sd.References {
+		protoSpan.References[i] = &protogen.SpanRef{
+			RefType: protogen.SpanRef_RefType(ref.Type),
+			TraceId: ref.TraceId,
+			SpanId:  ref.SpanId,
+		}
 	}
-	protoSpan.Logs = sp.logs
-	return proto.Marshal(protoSpan)
+	return protoSpan
 }
 
 func toProtoTag(tag Tag) *protogen.Tag {
@@ -83,6 +142,23 @@ func toProtoTag(tag Tag) *protogen.Tag {
 	return pTag
 }
 
+// toProtoTagV2 与 toProtoTag 相同,但浮点数使用 FLOAT 类型.v1 为了兼容旧的 collector 保留 BOOL 类型.
+func toProtoTagV2(tag Tag) *protogen.Tag {
+	pTag := toProtoTag(tag)
+	switch tag.Value.(type) {
+	case float32, float64:
+		pTag.Kind = protogen.Tag_FLOAT
+	}
+	return pTag
+}
+
+// serializeLogValue 编码 v2 中 log 字段的值:第一个字节为 Tag.Kind,之后为与 tag 相同编码的值,
+// 格式见 proto/span.proto 中 Field.value 的说明.
+func serializeLogValue(value interface{}) []byte {
+	pTag := toProtoTagV2(Tag{Value: value})
+	return append([]byte{byte(pTag.Kind)}, pTag.Value...)
+}
+
 func serializeInt64(v int64) []byte {
 	data := make([]byte, 8)
 	binary.BigEndian.PutUint64(data, uint64(v))
@@ -2,6 +2,11 @@ package trace
 
 import (
 	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	protogen "github.com/aluka-7/trace/proto"
 )
 
 func TestMarshalSpanV1(t *testing.T) {
@@ -11,8 +16,76 @@ func TestMarshalSpanV1(t *testing.T) {
 	sp1.SetLog(Log("hello", "test123"))
 	sp1.SetTag(TagString("tag1", "hell"), TagBool("booltag", true), TagFloat64("float64tag", 3.14159))
 	sp1.Finish(nil)
-	_, err := marshalSpanV1(sp1)
+	_, err := marshalSpanV1(report.sps[0], report.sps[0].spanTags())
 	if err != nil {
 		t.Error(err)
 	}
+	t.Run("test references", func(t *testing.T) {
+		p1 := t1.New("opt_producer").(*Span)
+		sp2 := t1.New("opt_server").(*Span)
+		sp3 := sp2.Follow("", "opt_producer").(*Span)
+		assert.Nil(t, sp3.Link(p1.TraceId()))
+		data, err := marshalSpan(newSpanData(sp3), protoVersion1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps := new(protogen.Span)
+		if err := proto.Unmarshal(data, ps); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, protoVersion1, ps.Version)
+		assert.Equal(t, []*protogen.SpanRef{
+			{RefType: protogen.SpanRef_FOLLOWS_FROM, TraceId: sp2.context.TraceId, SpanId: sp2.context.SpanId},
+			{RefType: protogen.SpanRef_FOLLOWS_FROM, TraceId: p1.context.TraceId, SpanId: p1.context.SpanId},
+		}, ps.References)
+	})
+}
+
+func TestMarshalSpanV2(t *testing.T) {
+	report := &mockReport{}
+	t1 := NewTracer("service1", nil, report, true, WithEnv("uat"))
+	sp1 := t1.New("opt_server").(*Span)
+	sp2 := sp1.Fork("", "opt_client").(*Span)
+	sp2.SetTag(TagFloat64("float64tag", 3.14159))
+	sp2.SetLog(Log("event", "retry"), LogInt64("attempt", 3), LogBool("ok", false), LogFloat64("ratio", 0.5))
+
+	unmarshal := func(sp *Span) *protogen.Span {
+		data, err := marshalSpan(newSpanData(sp), protoVersion2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps := new(protogen.Span)
+		if err := proto.Unmarshal(data, ps); err != nil {
+			t.Fatal(err)
+		}
+		return ps
+	}
+	ps := unmarshal(sp1)
+	assert.Equal(t, protoVersion2, ps.Version)
+	assert.Equal(t, "uat", ps.Env)
+	assert.Empty(t, ps.References, "root span has no parent")
+
+	ps = unmarshal(sp2)
+	assert.Equal(t, []*protogen.SpanRef{{RefType: protogen.SpanRef_CHILD_OF, TraceId: sp1.context.TraceId, SpanId: sp1.context.SpanId}}, ps.References)
+	for _, tag := range ps.Tags {
+		if tag.Key == "float64tag" {
+			assert.Equal(t, protogen.Tag_FLOAT, tag.Kind)
+			assert.Equal(t, serializeFloat64(3.14159), tag.Value)
+		}
+	}
+	fields := ps.Logs[0].Fields
+	assert.Equal(t, append([]byte{byte(protogen.Tag_STRING)}, "retry"...), fields[0].Value)
+	assert.Equal(t, append([]byte{byte(protogen.Tag_INT)}, serializeInt64(3)...), fields[1].Value)
+	assert.Equal(t, append([]byte{byte(protogen.Tag_BOOL)}, serializeBool(false)...), fields[2].Value)
+	assert.Equal(t, append([]byte{byte(protogen.Tag_FLOAT)}, serializeFloat64(0.5)...), fields[3].Value)
+	// v1 保持不变
+	assert.Equal(t, "3", sp2.logs[0].fields[1].Value)
+
+	sp3 := sp1.Follow("", "opt_producer").(*Span)
+	assert.Equal(t, protogen.SpanRef_FOLLOWS_FROM, unmarshal(sp3).References[0].RefType)
+	// 回收后的 span 恢复默认值
+	sp3.Finish(nil)
+	sp4 := sp1.Fork("", "opt_client").(*Span)
+	assert.Equal(t, protogen.SpanRef_CHILD_OF, sp4.refType)
+	assert.Empty(t, sp4.logs)
 }
@@ -25,25 +25,34 @@ func (m *MockTrace) Extract(format interface{}, carrier interface{}) (Trace, err
 // MockSpan .
 type MockSpan struct {
 	*MockTrace
+	// ServiceName Fork 与 Follow 传入的被调用服务
+	ServiceName   string
 	OperationName string
 	FinishErr     error
 	Finished      bool
 	Tags          []Tag
 	Logs          []LogField
+	// Links Link 传入的 context
+	Links []string
 }
 
 func (m *MockSpan) Fork(serviceName string, operationName string) Trace {
-	span := &MockSpan{OperationName: operationName, MockTrace: m.MockTrace}
+	span := &MockSpan{ServiceName: serviceName, OperationName: operationName, MockTrace: m.MockTrace}
 	m.Spans = append(m.Spans, span)
 	return span
 }
 
 func (m *MockSpan) Follow(serviceName string, operationName string) Trace {
-	span := &MockSpan{OperationName: operationName, MockTrace: m.MockTrace}
+	span := &MockSpan{ServiceName: serviceName, OperationName: operationName, MockTrace: m.MockTrace}
 	m.Spans = append(m.Spans, span)
 	return span
 }
 
+func (m *MockSpan) Link(contexts ...string) error {
+	m.Links = append(m.Links, contexts...)
+	return nil
+}
+
 func (m *MockSpan) Finish(err *error) {
 	if err != nil {
 		m.FinishErr = *err
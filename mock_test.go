@@ -3,6 +3,8 @@ package trace
 import (
 	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMockTrace(t *testing.T) {
@@ -11,8 +13,10 @@ func TestMockTrace(t *testing.T) {
 	mockTrace.Extract(nil, nil)
 
 	root := mockTrace.New("test")
-	root.Fork("", "")
-	root.Follow("", "")
+	root.Fork("redis", "GET")
+	root.Follow("kafka", "send")
+	assert.Equal(t, "redis", mockTrace.Spans[1].ServiceName)
+	assert.Equal(t, "kafka", mockTrace.Spans[2].ServiceName)
 	root.Finish(nil)
 	err := fmt.Errorf("test")
 	root.Finish(&err)
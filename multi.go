@@ -0,0 +1,224 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// multiError 合并多个错误.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// newMultiReport 创建将每个 span 分发到多个上报器的上报器.
+// 每个上报器拥有独立的队列与协程,某个上报器缓慢或者失败时不会阻塞其他上报器.
+// 队列满时最多等待 defaultWriteChannelTimeout,超时后该上报器进入拥塞状态,
+// 之后的 span 直接丢弃,直到其队列清空,因此持续缓慢的上报器不会拖慢写入.
+func newMultiReport(reporters ...reporter) *multiReport {
+	m := &multiReport{stats: &reportStats{}}
+	for _, r := range reporters {
+		d := &fanoutDest{
+			reporter: r,
+			spanCh:   make(chan *SpanData, dataChSize),
+			flushCh:  make(chan chan struct{}),
+			done:     make(chan struct{}),
+		}
+		go d.daemon()
+		m.dests = append(m.dests, d)
+	}
+	return m
+}
+
+type multiReport struct {
+	rmx    sync.RWMutex
+	closed bool
+	dests  []*fanoutDest
+	// stats 分发队列的统计数据
+	stats *reportStats
+}
+
+type fanoutDest struct {
+	reporter reporter
+	spanCh   chan *SpanData
+	// congested 为 1 时队列已满且等待超时,原子操作
+	congested int32
+	// flushCh 将请求之前进入队列的 span 交给上报器后关闭收到的 chan
+	flushCh chan chan struct{}
+	done    chan struct{}
+}
+
+func (d *fanoutDest) daemon() {
+	for {
+		select {
+		case sd, ok := <-d.spanCh:
+			if !ok {
+				close(d.done)
+				return
+			}
+			d.write(sd)
+			if len(d.spanCh) == 0 {
+				atomic.StoreInt32(&d.congested, 0)
+			}
+		case flushed := <-d.flushCh:
+			for pending := true; pending; {
+				select {
+				case sd, ok := <-d.spanCh:
+					if pending = ok; ok {
+						d.write(sd)
+					}
+				default:
+					pending = false
+				}
+			}
+			close(flushed)
+		}
+	}
+}
+
+// enqueue 将 span 放入队列,队列满时只有不处于拥塞状态才等待.
+func (d *fanoutDest) enqueue(sd *SpanData) bool {
+	select {
+	case d.spanCh <- sd:
+		return true
+	default:
+	}
+	if atomic.LoadInt32(&d.congested) == 1 {
+		return false
+	}
+	t := time.NewTimer(defaultWriteChannelTimeout)
+	defer t.Stop()
+	select {
+	case d.spanCh <- sd:
+		return true
+	case <-t.C:
+		atomic.StoreInt32(&d.congested, 1)
+		return false
+	}
+}
+
+func (d *fanoutDest) write(sd *SpanData) {
+	if err := d.reporter.WriteSpan(sd); err != nil {
+		errorf("write span to %T error: %s", d.reporter, err)
+	}
+}
+
+func (m *multiReport) WriteSpan(sd *SpanData) error {
+	m.rmx.RLock()
+	defer m.rmx.RUnlock()
+	if m.closed {
+		atomic.AddInt64(&m.stats.droppedClosed, 1)
+		return fmt.Errorf("report already closed")
+	}
+	// SpanData 为只读快照,各上报器共享同一份
+	var errs multiError
+	for _, d := range m.dests {
+		if !d.enqueue(sd) {
+			atomic.AddInt64(&m.stats.droppedTimeout, 1)
+			errs = append(errs, fmt.Errorf("%T queue full, span dropped", d.reporter))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Stats 返回各上报器统计数据之和,包括分发队列中的 span 以及分发时丢弃的 span.
+func (m *multiReport) Stats() ReportStats {
+	stats := m.stats.snapshot()
+	for _, d := range m.dests {
+		stats.QueueDepth += int64(len(d.spanCh))
+		if r, ok := d.reporter.(interface{ Stats() ReportStats }); ok {
+			stats = stats.add(r.Stats())
+		}
+	}
+	return stats
+}
+
+// Close 最多等待 1 秒分发队列处理完毕,之后依次调用各上报器的 Close.
+func (m *multiReport) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return m.shutdown(ctx, func(r reporter) error { return r.Close() })
+}
+
+// Flush 将分发队列中的 span 交给各上报器后依次 Flush,返回全部错误.
+func (m *multiReport) Flush(ctx context.Context) error {
+	m.rmx.RLock()
+	if m.closed {
+		m.rmx.RUnlock()
+		return fmt.Errorf("report already closed")
+	}
+	flushed := make([]chan struct{}, len(m.dests))
+	for i, d := range m.dests {
+		flushed[i] = make(chan struct{})
+		select {
+		case d.flushCh <- flushed[i]:
+		case <-ctx.Done():
+			m.rmx.RUnlock()
+			return ctx.Err()
+		}
+	}
+	m.rmx.RUnlock()
+
+	var errs multiError
+	for i, d := range m.dests {
+		select {
+		case <-flushed[i]:
+		case <-ctx.Done():
+			return append(errs, fmt.Errorf("%T: %w", d.reporter, ctx.Err()))
+		}
+		if err := flushReporter(ctx, d.reporter); err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", d.reporter, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Shutdown 等待各上报器的队列处理完毕后依次关闭,返回全部关闭错误.
+// 等待超时的上报器同样会被关闭,其队列中剩余的 span 被丢弃.
+func (m *multiReport) Shutdown(ctx context.Context) error {
+	return m.shutdown(ctx, func(r reporter) error { return shutdownReporter(ctx, r) })
+}
+
+func (m *multiReport) shutdown(ctx context.Context, closeFn func(r reporter) error) error {
+	m.rmx.Lock()
+	if m.closed {
+		m.rmx.Unlock()
+		return fmt.Errorf("report already closed")
+	}
+	m.closed = true
+	m.rmx.Unlock()
+
+	var errs multiError
+	for _, d := range m.dests {
+		close(d.spanCh)
+	}
+	for _, d := range m.dests {
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("%T close timeout, pending spans dropped", d.reporter))
+		}
+		if err := closeFn(d.reporter); err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", d.reporter, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
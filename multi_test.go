@@ -0,0 +1,108 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockReport 在 release 关闭前阻塞所有写入.
+type blockReport struct {
+	release  chan struct{}
+	closeErr error
+	closed   int32
+}
+
+func (b *blockReport) WriteSpan(sd *SpanData) error {
+	<-b.release
+	return nil
+}
+
+func (b *blockReport) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	return b.closeErr
+}
+
+// syncReport 并发安全的 mockReport.
+type syncReport struct {
+	mu  sync.Mutex
+	sps []*SpanData
+}
+
+func (s *syncReport) WriteSpan(sd *SpanData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sps = append(s.sps, sd)
+	return nil
+}
+
+func (s *syncReport) Close() error {
+	return nil
+}
+
+func TestMultiReport(t *testing.T) {
+	t.Run("test fan out", func(t *testing.T) {
+		r1, r2 := &syncReport{}, &syncReport{}
+		report := newMultiReport(r1, r2)
+		t1 := NewTracer("service1", nil, report, true)
+		sp1 := t1.New("opt_server")
+		sp1.Fork("", "opt_client").Finish(nil)
+		sp1.Finish(nil)
+		// 复用对象池中的 span,不应影响已分发的副本
+		t1.New("opt_other").SetTag(TagString("reused", "true"))
+		assert.Nil(t, report.Close())
+		for _, r := range []*syncReport{r1, r2} {
+			assert.Len(t, r.sps, 2)
+			assert.Equal(t, "opt_client", r.sps[0].OperationName)
+			assert.Equal(t, "opt_server", r.sps[1].OperationName)
+		}
+		assert.True(t, r1.sps[0] == r2.sps[0], "share one copy")
+	})
+	t.Run("test slow destination isolated", func(t *testing.T) {
+		fast := &syncReport{}
+		slow := &blockReport{release: make(chan struct{})}
+		report := newMultiReport(slow, fast)
+		t1 := NewTracer("service1", nil, report, true)
+		// 缓慢的上报器阻塞时写入不会被阻塞,也不会影响其他上报器
+		start := time.Now()
+		for i := 0; i < dataChSize+10; i++ {
+			t1.New("opt").Finish(nil)
+		}
+		assert.True(t, time.Since(start) < time.Second, "writes blocked by slow destination")
+		assert.True(t, report.Stats().DroppedTimeout > 0)
+		close(slow.release)
+		assert.Nil(t, report.Close())
+		assert.Len(t, fast.sps, dataChSize+10)
+	})
+	t.Run("test shutdown closes timed out destination", func(t *testing.T) {
+		slow := &blockReport{release: make(chan struct{}), closeErr: fmt.Errorf("close slow")}
+		defer close(slow.release)
+		report := newMultiReport(slow)
+		assert.Nil(t, report.WriteSpan(&SpanData{}))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := report.Shutdown(ctx)
+		assert.Len(t, err, 2)
+		assert.Contains(t, err.Error(), "close timeout")
+		assert.Contains(t, err.Error(), "close slow")
+		assert.Equal(t, int32(1), atomic.LoadInt32(&slow.closed))
+	})
+	t.Run("test close errors", func(t *testing.T) {
+		r1 := &blockReport{release: make(chan struct{}), closeErr: fmt.Errorf("close r1")}
+		r2 := &blockReport{release: make(chan struct{}), closeErr: fmt.Errorf("close r2")}
+		close(r1.release)
+		close(r2.release)
+		report := newReporter(&Config{Reporters: []*Config{{Network: "console"}, {Network: "console"}}}).(*multiReport)
+		report.dests[0].reporter, report.dests[1].reporter = r1, r2
+		err := report.Close()
+		assert.Len(t, err, 2)
+		assert.Contains(t, err.Error(), "close r1")
+		assert.Contains(t, err.Error(), "close r2")
+		assert.NotNil(t, report.WriteSpan(&SpanData{}))
+	})
+}
@@ -28,6 +28,10 @@ func (n noopSpan) Follow(string, string) Trace {
 	return noopSpan{}
 }
 
+func (n noopSpan) Link(...string) error {
+	return nil
+}
+
 func (n noopSpan) Finish(err *error) {}
 
 func (n noopSpan) SetTag(tags ...Tag) Trace {
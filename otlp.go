@@ -0,0 +1,358 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	otlpTracesPath = "/v1/traces"
+	// otlpScopeName 上报时使用的 InstrumentationScope 名称
+	otlpScopeName = "github.com/aluka-7/trace"
+
+	otlpMaxRetries     = 5
+	otlpInitialBackoff = 100 * time.Millisecond
+	otlpMaxBackoff     = 5 * time.Second
+)
+
+// OTLP SpanKind 与 StatusCode,见 opentelemetry/proto/trace/v1/trace.proto
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+	otlpKindProducer = 4
+	otlpKindConsumer = 5
+
+	otlpStatusError = 2
+)
+
+// newOTLPReport 创建以 OTLP/HTTP protobuf 格式批量上报的上报器.
+func newOTLPReport(cfg *Config) *otlpReport {
+	timeout := configTimeout(cfg, defaultExportTimeout)
+	batchSize, interval := batchConfig(cfg)
+	report := &otlpReport{
+		url:         otlpURL(cfg.Addr),
+		client:      &http.Client{Timeout: timeout},
+		retryPolicy: defaultRetryPolicy,
+		resources:   newResourceCache(encodeOTLPResource),
+	}
+	report.batch = newBatcher(batchSize, defaultHTTPBatchBytes, interval, report.flush)
+	go report.batch.daemon()
+	return report
+}
+
+// otlpURL 地址未指定路径时使用 OTLP/HTTP 默认的上报路径.
+func otlpURL(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || (u.Path != "" && u.Path != "/") {
+		return addr
+	}
+	u.Path = otlpTracesPath
+	return u.String()
+}
+
+type otlpReport struct {
+	retryPolicy
+	url       string
+	client    *http.Client
+	batch     *batcher
+	resources *resourceCache
+}
+
+// retryPolicy 导出失败时的重试策略,指数退避,服务端给出等待时间时以服务端为准.
+type retryPolicy struct {
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	maxRetries: otlpMaxRetries,
+	backoff:    otlpInitialBackoff,
+	maxBackoff: otlpMaxBackoff,
+}
+
+// do 执行 fn 直到成功、不可重试、超过重试次数或者 ctx 结束.
+// fn 返回是否可重试以及服务端建议的等待时间,为 0 时使用退避时间.
+func (p retryPolicy) do(ctx context.Context, fn func(ctx context.Context) (retry bool, after time.Duration, err error)) error {
+	backoff := p.backoff
+	for i := 0; ; i++ {
+		retry, after, err := fn(ctx)
+		if err == nil || !retry || i >= p.maxRetries {
+			return err
+		}
+		if after == 0 {
+			after = backoff
+			backoff *= 2
+		}
+		if after > p.maxBackoff {
+			after = p.maxBackoff
+		}
+		t := time.NewTimer(after)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w, last error: %s", ctx.Err(), err)
+		case <-t.C:
+		}
+	}
+}
+
+func (o *otlpReport) WriteSpan(sd *SpanData) error {
+	// 记录服务的 Resource,发送时按服务名读取
+	o.resources.get(sd)
+	return o.batch.write(appendServiceItem(sd.ServiceName, appendOTLPSpan(nil, sd)))
+}
+
+func (o *otlpReport) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.client.Timeout+time.Second)
+	defer cancel()
+	return o.Shutdown(ctx)
+}
+
+// Flush 立即发送队列中的 span,等待发送完成或者 ctx 结束.
+func (o *otlpReport) Flush(ctx context.Context) error {
+	return o.batch.flushContext(ctx)
+}
+
+// Shutdown 停止接收 span,等待队列中的 span 发送完成或者 ctx 结束.
+func (o *otlpReport) Shutdown(ctx context.Context) error {
+	return o.batch.shutdown(ctx)
+}
+
+// Stats 返回上报器的统计数据.
+func (o *otlpReport) Stats() ReportStats {
+	return o.batch.Stats()
+}
+
+func (o *otlpReport) flush(ctx context.Context, batch [][]byte) {
+	body := &bytes.Buffer{}
+	zw := gzip.NewWriter(body)
+	zw.Write(encodeOTLPRequest(batch, o.resources))
+	zw.Close()
+	err := o.do(ctx, func(ctx context.Context) (bool, time.Duration, error) {
+		return o.post(ctx, body.Bytes())
+	})
+	o.batch.stats.result(len(batch), err)
+	if err != nil {
+		errorf("export spans to otlp error: %s", err)
+	}
+}
+
+// post 发送一次请求,返回是否可重试以及服务端通过 Retry-After 建议的等待时间.
+func (o *otlpReport) post(ctx context.Context, body []byte) (retry bool, after time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return false, 0, nil
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			after = time.Duration(seconds) * time.Second
+		}
+		return true, after, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// encodeOTLPRequest 将 appendServiceItem 编码的 span 按服务分组,
+// 编码为 ExportTraceServiceRequest,每个服务对应一个 ResourceSpans.
+func encodeOTLPRequest(batch [][]byte, resources *resourceCache) []byte {
+	var req []byte
+	services, groups := groupByService(batch)
+	for _, service := range services {
+		var scope, scopeSpans, resourceSpans []byte
+		resource := resources.load(service)
+		// ScopeSpans
+		scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+		scope = protowire.AppendString(scope, otlpScopeName)
+		scopeSpans = protowire.AppendTag(scopeSpans, 1, protowire.BytesType)
+		scopeSpans = protowire.AppendBytes(scopeSpans, scope)
+		for _, span := range groups[service] {
+			scopeSpans = protowire.AppendTag(scopeSpans, 2, protowire.BytesType)
+			scopeSpans = protowire.AppendBytes(scopeSpans, span)
+		}
+		// ResourceSpans
+		resourceSpans = protowire.AppendTag(resourceSpans, 1, protowire.BytesType)
+		resourceSpans = protowire.AppendBytes(resourceSpans, resource)
+		resourceSpans = protowire.AppendTag(resourceSpans, 2, protowire.BytesType)
+		resourceSpans = protowire.AppendBytes(resourceSpans, scopeSpans)
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, resourceSpans)
+	}
+	return req
+}
+
+// encodeOTLPResource 将服务名与 Resource 的属性编码为 opentelemetry.proto.resource.v1.Resource.
+func encodeOTLPResource(service string, r *Resource) []byte {
+	resource := appendOTLPAttribute(nil, 1, "service.name", service)
+	if r != nil {
+		for _, tag := range r.Tags {
+			resource = appendOTLPAttribute(resource, 1, tag.Key, tag.Value)
+		}
+	}
+	return resource
+}
+
+// appendOTLPSpan 将 span 编码为 opentelemetry.proto.trace.v1.Span.
+func appendOTLPSpan(b []byte, sd *SpanData) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, otlpTraceID(sd.TraceId))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, otlpSpanID(sd.SpanId))
+	if sd.ParentId != 0 {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, otlpSpanID(sd.ParentId))
+	}
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendString(b, sd.OperationName)
+	var (
+		kind    uint64 = otlpKindInternal
+		failed  bool
+		message string
+	)
+	for _, tag := range sd.Tags {
+		switch tag.Key {
+		case TagSpanKind:
+			kind = otlpKind(fmt.Sprint(tag.Value))
+			continue
+		case TagError:
+			failed, _ = tag.Value.(bool)
+		}
+		b = appendOTLPAttribute(b, 9, tag.Key, tag.Value)
+	}
+	b = protowire.AppendTag(b, 6, protowire.VarintType)
+	b = protowire.AppendVarint(b, kind)
+	b = protowire.AppendTag(b, 7, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(sd.StartTime.UnixNano()))
+	b = protowire.AppendTag(b, 8, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(sd.StartTime.Add(sd.Duration).UnixNano()))
+	for _, log := range sd.Logs {
+		var event []byte
+		name := "log"
+		event = protowire.AppendTag(event, 1, protowire.Fixed64Type)
+		event = protowire.AppendFixed64(event, uint64(log.Timestamp.UnixNano()))
+		for _, field := range log.Fields {
+			value := logValueString(field.Value)
+			switch field.Key {
+			case LogEvent:
+				name = value
+			case LogMessage:
+				message = value
+			}
+			event = appendOTLPAttribute(event, 3, field.Key, value)
+		}
+		event = protowire.AppendTag(event, 2, protowire.BytesType)
+		event = protowire.AppendString(event, name)
+		b = protowire.AppendTag(b, 11, protowire.BytesType)
+		b = protowire.AppendBytes(b, event)
+	}
+	for _, ref := range sd.References {
+		// 父 span 已经写入 parent_span_id
+		if ref.SpanId == sd.ParentId && ref.TraceId == sd.TraceId {
+			continue
+		}
+		var link []byte
+		link = protowire.AppendTag(link, 1, protowire.BytesType)
+		link = protowire.AppendBytes(link, otlpTraceID(ref.TraceId))
+		link = protowire.AppendTag(link, 2, protowire.BytesType)
+		link = protowire.AppendBytes(link, otlpSpanID(ref.SpanId))
+		b = protowire.AppendTag(b, 13, protowire.BytesType)
+		b = protowire.AppendBytes(b, link)
+	}
+	if failed {
+		var status []byte
+		status = protowire.AppendTag(status, 2, protowire.BytesType)
+		status = protowire.AppendString(status, message)
+		status = protowire.AppendTag(status, 3, protowire.VarintType)
+		status = protowire.AppendVarint(status, otlpStatusError)
+		b = protowire.AppendTag(b, 15, protowire.BytesType)
+		b = protowire.AppendBytes(b, status)
+	}
+	return b
+}
+
+// appendOTLPAttribute 将 key/value 编码为 KeyValue 并作为 num 字段追加到 b.
+func appendOTLPAttribute(b []byte, num protowire.Number, key string, value interface{}) []byte {
+	var kv, anyValue []byte
+	switch v := value.(type) {
+	case string:
+		anyValue = protowire.AppendTag(anyValue, 1, protowire.BytesType)
+		anyValue = protowire.AppendString(anyValue, v)
+	case bool:
+		anyValue = protowire.AppendTag(anyValue, 2, protowire.VarintType)
+		anyValue = protowire.AppendVarint(anyValue, protowire.EncodeBool(v))
+	case int:
+		anyValue = protowire.AppendTag(anyValue, 3, protowire.VarintType)
+		anyValue = protowire.AppendVarint(anyValue, uint64(v))
+	case int32:
+		anyValue = protowire.AppendTag(anyValue, 3, protowire.VarintType)
+		anyValue = protowire.AppendVarint(anyValue, uint64(v))
+	case int64:
+		anyValue = protowire.AppendTag(anyValue, 3, protowire.VarintType)
+		anyValue = protowire.AppendVarint(anyValue, uint64(v))
+	case float32:
+		anyValue = protowire.AppendTag(anyValue, 4, protowire.Fixed64Type)
+		anyValue = protowire.AppendFixed64(anyValue, math.Float64bits(float64(v)))
+	case float64:
+		anyValue = protowire.AppendTag(anyValue, 4, protowire.Fixed64Type)
+		anyValue = protowire.AppendFixed64(anyValue, math.Float64bits(v))
+	default:
+		anyValue = protowire.AppendTag(anyValue, 1, protowire.BytesType)
+		anyValue = protowire.AppendString(anyValue, fmt.Sprintf("%v", v))
+	}
+	kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+	kv = protowire.AppendString(kv, key)
+	kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+	kv = protowire.AppendBytes(kv, anyValue)
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, kv)
+}
+
+func otlpKind(kind string) uint64 {
+	switch kind {
+	case "server":
+		return otlpKindServer
+	case "client":
+		return otlpKindClient
+	case "producer":
+		return otlpKindProducer
+	case "consumer":
+		return otlpKindConsumer
+	default:
+		return otlpKindInternal
+	}
+}
+
+// otlpTraceID OTLP 的 trace id 为 16 字节,高 8 字节补 0.
+func otlpTraceID(id uint64) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b[8:], id)
+	return b
+}
+
+func otlpSpanID(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
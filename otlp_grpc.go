@@ -0,0 +1,220 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	otlpExportMethod = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+	retryInfoTypeURL = "type.googleapis.com/google.rpc.RetryInfo"
+)
+
+var _ encoding.Codec = rawCodec{}
+
+// rawCodec 直接收发已编码的 protobuf 数据.
+// 名称与 gRPC 默认的 proto 编码相同,因此服务端看到的是标准的 application/grpc+proto 请求.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want *[]byte", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want *[]byte", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// newOTLPGRPCReport 创建通过 OTLP/gRPC 批量上报的上报器,每次导出的超时时间为 Config.Timeout.
+// TLS 配置错误或者连接创建失败时不会退回明文连接,上报器丢弃全部 span 并计入 Failed.
+func newOTLPGRPCReport(cfg *Config, opts ...grpc.DialOption) *otlpGRPCReport {
+	timeout := configTimeout(cfg, defaultExportTimeout)
+	batchSize, interval := batchConfig(cfg)
+	report := &otlpGRPCReport{
+		retryPolicy: defaultRetryPolicy,
+		timeout:     timeout,
+		resources:   newResourceCache(encodeOTLPResource),
+	}
+	if len(opts) == 0 {
+		if cfg.TLS != nil {
+			// TLS 配置错误时不能退回明文连接
+			network := "tcp"
+			if strings.HasPrefix(cfg.Addr, "unix:") {
+				network = "unix"
+			}
+			if conf, err := newTLSConfig(cfg.TLS, network, cfg.Addr); err != nil {
+				report.dialErr = err
+			} else {
+				opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(conf))}
+			}
+		} else {
+			opts = []grpc.DialOption{grpc.WithInsecure()}
+		}
+	}
+	if report.dialErr == nil {
+		report.conn, report.dialErr = grpc.Dial(cfg.Addr, opts...)
+	}
+	if report.dialErr != nil {
+		errorf("create otlp grpc connection error: %s, spans will be dropped", report.dialErr)
+	}
+	report.batch = newBatcher(batchSize, defaultHTTPBatchBytes, interval, report.flush)
+	go report.batch.daemon()
+	return report
+}
+
+type otlpGRPCReport struct {
+	retryPolicy
+	conn *grpc.ClientConn
+	// dialErr 不为空时连接创建失败,conn 为空
+	dialErr   error
+	timeout   time.Duration
+	batch     *batcher
+	resources *resourceCache
+}
+
+func (o *otlpGRPCReport) WriteSpan(sd *SpanData) error {
+	// 记录服务的 Resource,发送时按服务名读取
+	o.resources.get(sd)
+	return o.batch.write(appendServiceItem(sd.ServiceName, appendOTLPSpan(nil, sd)))
+}
+
+func (o *otlpGRPCReport) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout+time.Second)
+	defer cancel()
+	return o.Shutdown(ctx)
+}
+
+// Flush 立即发送队列中的 span,等待发送完成或者 ctx 结束.
+func (o *otlpGRPCReport) Flush(ctx context.Context) error {
+	return o.batch.flushContext(ctx)
+}
+
+// Shutdown 停止接收 span,等待队列中的 span 发送完成或者 ctx 结束后关闭连接.
+func (o *otlpGRPCReport) Shutdown(ctx context.Context) error {
+	err := o.batch.shutdown(ctx)
+	if err == errReportClosed {
+		return err
+	}
+	if o.conn != nil {
+		o.conn.Close()
+	}
+	return err
+}
+
+// Stats 返回上报器的统计数据.
+func (o *otlpGRPCReport) Stats() ReportStats {
+	return o.batch.Stats()
+}
+
+func (o *otlpGRPCReport) flush(ctx context.Context, batch [][]byte) {
+	if o.dialErr != nil {
+		o.batch.stats.result(len(batch), o.dialErr)
+		return
+	}
+	req := encodeOTLPRequest(batch, o.resources)
+	err := o.do(ctx, func(ctx context.Context) (bool, time.Duration, error) {
+		return o.export(ctx, req)
+	})
+	o.batch.stats.result(len(batch), err)
+	if err != nil {
+		errorf("export spans to otlp error: %s", err)
+	}
+}
+
+// export 调用一次 TraceService/Export,返回是否可重试以及服务端通过 RetryInfo 建议的等待时间.
+func (o *otlpGRPCReport) export(ctx context.Context, req []byte) (retry bool, after time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+	var resp []byte
+	err = o.conn.Invoke(ctx, otlpExportMethod, &req, &resp, grpc.ForceCodec(rawCodec{}))
+	if err == nil {
+		return false, 0, nil
+	}
+	st := status.Convert(err)
+	after = retryDelay(st)
+	switch st.Code() {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return true, after, err
+	case codes.ResourceExhausted:
+		// 服务端只有在给出 RetryInfo 时才表示可以恢复
+		return after > 0, after, err
+	default:
+		return false, 0, err
+	}
+}
+
+// retryDelay 解析错误详情中的 google.rpc.RetryInfo.
+func retryDelay(st *status.Status) time.Duration {
+	for _, detail := range st.Proto().GetDetails() {
+		if detail.GetTypeUrl() != retryInfoTypeURL {
+			continue
+		}
+		// RetryInfo{retry_delay: Duration{seconds: 1, nanos: 2}}
+		b := detail.GetValue()
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				return 0
+			}
+			b = b[n:]
+			if num != 1 || typ != protowire.BytesType {
+				if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+					return 0
+				}
+				b = b[n:]
+				continue
+			}
+			delay, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return 0
+			}
+			return parseDuration(delay)
+		}
+	}
+	return 0
+}
+
+// parseDuration 解码 google.protobuf.Duration.
+func parseDuration(b []byte) time.Duration {
+	var seconds, nanos uint64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.VarintType {
+			return 0
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return 0
+		}
+		b = b[n:]
+		switch num {
+		case 1:
+			seconds = v
+		case 2:
+			nanos = v
+		}
+	}
+	return time.Duration(int64(seconds))*time.Second + time.Duration(int32(nanos))
+}
@@ -0,0 +1,139 @@
+package trace
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/stretchr/testify/assert"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// rawServerCodec 服务端使用的 rawCodec,grpc.CustomCodec 需要 String 方法.
+type rawServerCodec struct {
+	rawCodec
+}
+
+func (rawServerCodec) String() string {
+	return "proto"
+}
+
+// mockTraceService 测试用的 TraceService,handler 返回的错误会依次作为每次请求的结果.
+type mockTraceService struct {
+	mu       sync.Mutex
+	errs     []error
+	requests [][]byte
+	attempts int
+}
+
+func (m *mockTraceService) export(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	var req []byte
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attempts++; m.attempts <= len(m.errs) {
+		return nil, m.errs[m.attempts-1]
+	}
+	m.requests = append(m.requests, req)
+	resp := []byte{}
+	return &resp, nil
+}
+
+func newOTLPGRPCServer(t *testing.T, svc *mockTraceService) *bufconn.Listener {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.CustomCodec(rawServerCodec{}))
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "opentelemetry.proto.collector.trace.v1.TraceService",
+		HandlerType: (*interface{})(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Export", Handler: svc.export}},
+	}, svc)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis
+}
+
+func retryInfo(delay time.Duration) error {
+	var duration, info []byte
+	duration = protowire.AppendTag(duration, 1, protowire.VarintType)
+	duration = protowire.AppendVarint(duration, uint64(delay/time.Second))
+	duration = protowire.AppendTag(duration, 2, protowire.VarintType)
+	duration = protowire.AppendVarint(duration, uint64(delay%time.Second))
+	info = protowire.AppendTag(info, 1, protowire.BytesType)
+	info = protowire.AppendBytes(info, duration)
+	return status.ErrorProto(&spb.Status{
+		Code:    int32(codes.ResourceExhausted),
+		Message: "slow down",
+		Details: []*any.Any{{TypeUrl: retryInfoTypeURL, Value: info}},
+	})
+}
+
+func TestOTLPGRPCReport(t *testing.T) {
+	svc := &mockTraceService{errs: []error{
+		status.Error(codes.Unavailable, "unavailable"),
+		retryInfo(time.Millisecond),
+	}}
+	lis := newOTLPGRPCServer(t, svc)
+	report := newOTLPGRPCReport(&Config{Addr: "bufnet"},
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+	)
+	report.backoff = time.Millisecond
+	t1 := NewTracer("service1", nil, report, true)
+	sp1 := t1.New("opt_server")
+	sp1.Fork("", "opt_client").Finish(nil)
+	sp1.Finish(nil)
+	if err := report.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 3, svc.attempts)
+	assert.Len(t, svc.requests, 1)
+	spans := decodeOTLPSpans(t, svc.requests[0])["service1"]
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "opt_client", spans[0].string(5))
+	assert.Equal(t, "opt_server", spans[1].string(5))
+}
+
+func TestOTLPGRPCReportNotRetry(t *testing.T) {
+	svc := &mockTraceService{errs: []error{
+		status.Error(codes.InvalidArgument, "invalid"),
+		status.Error(codes.ResourceExhausted, "no retry info"),
+	}}
+	lis := newOTLPGRPCServer(t, svc)
+	report := newOTLPGRPCReport(&Config{Addr: "bufnet", BatchSize: 2},
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+	)
+	t1 := NewTracer("service1", nil, report, true)
+	for i := 0; i < 4; i++ {
+		t1.New("opt").Finish(nil)
+	}
+	report.Close()
+	assert.Equal(t, 2, svc.attempts)
+	assert.Empty(t, svc.requests)
+}
+
+func TestOTLPGRPCReportInvalidTLS(t *testing.T) {
+	report := newReporter(&Config{Network: "otlpgrpc", Addr: "127.0.0.1:4317", TLS: &TLSConfig{CAFile: "missing.crt"}}).(*otlpGRPCReport)
+	assert.NotNil(t, report.dialErr)
+	t1 := NewTracer("service1", nil, report, true)
+	t1.New("opt").Finish(nil)
+	assert.Nil(t, report.Close())
+	assert.Equal(t, int64(1), report.Stats().Failed)
+}
+
+func TestRetryDelay(t *testing.T) {
+	st := status.Convert(retryInfo(1500 * time.Millisecond))
+	assert.Equal(t, 1500*time.Millisecond, retryDelay(st))
+	assert.Equal(t, time.Duration(0), retryDelay(status.New(codes.Unavailable, "")))
+}
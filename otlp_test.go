@@ -0,0 +1,189 @@
+package trace
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields 测试用的 protobuf 解码结果,bytes 类型为 []byte,varint 与 fixed 类型为 uint64.
+type protoFields map[protowire.Number][]interface{}
+
+func decodeProto(t *testing.T, b []byte) protoFields {
+	fields := make(protoFields)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		var value interface{}
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			value = uint64(v)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		fields[num] = append(fields[num], value)
+	}
+	return fields
+}
+
+func (f protoFields) message(t *testing.T, num protowire.Number) protoFields {
+	return decodeProto(t, f[num][0].([]byte))
+}
+
+func (f protoFields) messages(t *testing.T, num protowire.Number) []protoFields {
+	var msgs []protoFields
+	for _, value := range f[num] {
+		msgs = append(msgs, decodeProto(t, value.([]byte)))
+	}
+	return msgs
+}
+
+func (f protoFields) string(num protowire.Number) string {
+	return string(f[num][0].([]byte))
+}
+
+// attributes 解码 KeyValue 列表,返回 key 到 AnyValue 的映射.
+func (f protoFields) attributes(t *testing.T, num protowire.Number) map[string]protoFields {
+	attrs := make(map[string]protoFields)
+	for _, kv := range f.messages(t, num) {
+		attrs[kv.string(1)] = kv.message(t, 2)
+	}
+	return attrs
+}
+
+// decodeOTLPSpans 解码 ExportTraceServiceRequest,返回服务名与其全部 span.
+func decodeOTLPSpans(t *testing.T, req []byte) map[string][]protoFields {
+	spans := make(map[string][]protoFields)
+	for _, rs := range decodeProto(t, req).messages(t, 1) {
+		service := rs.message(t, 1).attributes(t, 1)["service.name"].string(1)
+		for _, ss := range rs.messages(t, 2) {
+			assert.Equal(t, otlpScopeName, ss.message(t, 1).string(1))
+			spans[service] = append(spans[service], ss.messages(t, 2)...)
+		}
+	}
+	return spans
+}
+
+func TestOTLPReport(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		spans    = make(map[string][]protoFields)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, otlpTracesPath, r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		mu.Lock()
+		defer mu.Unlock()
+		// 第一次请求返回 503 以验证重试
+		if requests++; requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for service, sps := range decodeOTLPSpans(t, body) {
+			spans[service] = append(spans[service], sps...)
+		}
+	}))
+	defer srv.Close()
+
+	report := newOTLPReport(&Config{Addr: srv.URL})
+	report.backoff = time.Millisecond
+	t1 := NewTracer("service1", nil, report, true)
+	t2 := NewTracer("service2", nil, report, true)
+	sp1 := t1.New("opt_server")
+	sp2 := sp1.Fork("", "opt_client").SetTag(TagInt(TagPeerPort, 6379), TagFloat64("ratio", 0.5))
+	err := fmt.Errorf("redis timeout")
+	sp2.Finish(&err)
+	sp1.Finish(nil)
+	t2.New("opt_other").Finish(nil)
+	if err := report.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, requests)
+	assert.Len(t, spans["service1"], 2)
+	assert.Len(t, spans["service2"], 1)
+	client, server := spans["service1"][0], spans["service1"][1]
+	assert.Equal(t, "opt_server", server.string(5))
+	assert.Equal(t, uint64(otlpKindServer), server[6][0])
+	assert.Nil(t, server[4])
+	assert.Len(t, server[1][0], 16)
+	assert.Equal(t, server[1], client[1])
+	assert.Equal(t, server[2], client[4])
+	assert.Equal(t, uint64(otlpKindClient), client[6][0])
+	assert.True(t, client[8][0].(uint64) >= client[7][0].(uint64))
+
+	attrs := client.attributes(t, 9)
+	assert.Equal(t, uint64(6379), attrs[TagPeerPort][3][0])
+	assert.Equal(t, uint64(1), attrs[TagError][2][0])
+	assert.NotNil(t, attrs["ratio"][4])
+	events := client.messages(t, 11)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "redis timeout", events[0].attributes(t, 3)[LogMessage].string(1))
+	status := client.message(t, 15)
+	assert.Equal(t, uint64(otlpStatusError), status[3][0])
+	assert.Equal(t, "redis timeout", status.string(2))
+}
+
+func TestOTLPReportNotRetry(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	report := newOTLPReport(&Config{Addr: srv.URL})
+	NewTracer("service1", nil, report, true).New("opt").Finish(nil)
+	report.Close()
+	assert.Equal(t, 1, requests)
+}
+
+func TestOTLPReportFlushTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	report := newOTLPReport(&Config{Addr: srv.URL})
+	NewTracer("service1", nil, report, true).New("opt").Finish(nil)
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, report.Flush(ctx), context.DeadlineExceeded)
+	assert.Nil(t, report.Close())
+	// 重试的等待随 ctx 结束,不会等到 Retry-After 或者退避上限
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, int64(1), report.Stats().Failed)
+}
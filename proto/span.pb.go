@@ -165,7 +165,11 @@ func (m *Tag) GetValue() []byte {
 }
 
 type Field struct {
-	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// version 1: the value as a UTF-8 string.
+	// version 2: the first byte is Tag.Kind, followed by the value encoded as Tag.value
+	// of that kind (STRING: UTF-8, INT: 8-byte big-endian int64, BOOL: 1 byte,
+	// FLOAT: 8-byte big-endian IEEE 754 float64).
 	Value                []byte   `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -1,11 +1,16 @@
 package trace
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
-	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/aluka-7/utils"
 )
 
 const (
@@ -17,113 +22,338 @@ const (
 	dataChSize                 = 4096
 	defaultWriteChannelTimeout = 50 * time.Millisecond
 	defaultWriteTimeout        = 200 * time.Millisecond
+
+	// 连接不可用时内存中暂存数据的大小上限
+	defaultQueueSize  = 1024 * 1024 * 32
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
 )
 
 // reporter trace reporter.
+// WriteSpan 收到的 SpanData 为只读快照,异步上报的实现可以直接持有,不需要复制.
 type reporter interface {
-	WriteSpan(sp *Span) error
+	WriteSpan(sd *SpanData) error
 	Close() error
 }
 
 // newReport with network address
 func newReport(network, address string, timeout time.Duration, protocolVersion int32) reporter {
-	if timeout == 0 {
-		timeout = defaultWriteTimeout
-	}
+	return newConnReport(&Config{
+		Network:         network,
+		Addr:            address,
+		Timeout:         utils.Duration(timeout),
+		ProtocolVersion: protocolVersion,
+	})
+}
+
+// newConnReport 根据配置创建上报器,BatchSize 大于 1 时将多个 span 合并为一次写入.
+func newConnReport(cfg *Config) *connReport {
 	report := &connReport{
-		network: network,
-		address: address,
-		dataCh:  make(chan []byte, dataChSize),
-		done:    make(chan struct{}),
-		timeout: timeout,
-		version: protocolVersion,
-	}
-	go report.daemon()
+		network: cfg.Network,
+		address: cfg.Addr,
+		timeout: configTimeout(cfg, defaultWriteTimeout),
+		version: cfg.ProtocolVersion,
+		// v1 保持原有的格式,直接写入 span 的编码,帧格式从 v2 开始
+		framed: cfg.ProtocolVersion >= protoVersion2 && isStreamNetwork(cfg.Network),
+	}
+	if report.version == 0 {
+		report.version = protoVersion1
+	}
+	// BatchSize 小于等于 1 时每次写入一个 span,只使用默认的刷新间隔
+	_, interval := batchConfig(cfg)
+	report.batch = newBatcher(cfg.BatchSize, maxPackageSize, interval, report.flush)
+	if cfg.BatchSize > 1 {
+		report.batched = true
+		report.batch.overhead = func(n int) int { return frameSize(n) - n }
+	}
+	if report.batched || report.framed {
+		report.resources = newResourceFrames(report.version)
+	}
+	if flag, err := compressFlag(cfg.Compression); err != nil {
+		report.Errorf("%s, compression disabled", err)
+	} else if flag != 0 {
+		if report.batched {
+			report.compressor = newCompressor(flag)
+		} else {
+			report.Errorf("compression requires batch_size > 1, compression disabled")
+		}
+	}
+	if cfg.TLS != nil {
+		// TLS 配置错误时不能退回明文连接,每次连接都返回该错误
+		if !isStreamNetwork(cfg.Network) {
+			report.dialErr = fmt.Errorf("trace: tls requires a stream network, got %s", cfg.Network)
+		} else if report.tlsConfig, report.dialErr = newTLSConfig(cfg.TLS, cfg.Network, cfg.Addr); report.dialErr != nil {
+			report.Errorf("load tls config error: %s", report.dialErr)
+		}
+	}
+	report.queue = newMemQueue(defaultQueueSize)
+	if cfg.SpoolDir != "" {
+		sp, err := openSpool(cfg.SpoolDir, cfg.SpoolSize)
+		if err != nil {
+			report.Errorf("open spool error: %s, use memory queue", err)
+		} else {
+			report.queue = sp
+		}
+	}
+	report.backoff = backoff{min: defaultMinBackoff, max: defaultMaxBackoff}
+	// 没有新数据时也定期尝试重发暂存的数据
+	report.batch.onTick = report.drain
+	report.batch.onFlush = report.drainContext
+	go report.batch.daemon()
 	return report
 }
 
 type connReport struct {
 	version int32
-	rmx     sync.RWMutex
-	closed  bool
 
 	network, address string
 
-	dataCh chan []byte
+	// batched 为 true 时每次写入一个批量包,见 encodeBatch
+	batched bool
+	// framed 为 true 时每个 span 以帧的形式写入,见 appendFrame.只用于 v2 及以上版本的流式网络
+	framed bool
+	batch  *batcher
+	// compressor 不为空时将每个批量包压缩为一帧,见 compress.go
+	compressor *compressor
+	// resources 不为空时 span 不再写入 NewTracer 传入的 tag,写入数据前先写入 Resource 帧,
+	// announced 为当前连接上已经写入的 Resource 帧数量
+	resources *resourceFrames
+	announced int
 
 	conn net.Conn
-
-	done chan struct{}
+	// tlsConfig 不为空时使用 TLS 连接
+	tlsConfig *tls.Config
+	dialErr   error
 
 	timeout time.Duration
-}
 
-func (c *connReport) daemon() {
-	for b := range c.dataCh {
-		c.send(b)
-	}
-	c.done <- struct{}{}
+	// queue 连接不可用时暂存数据,连接恢复后按顺序重发
+	queue   sendQueue
+	state   int32
+	backoff backoff
+	// retryAt 之前不再尝试连接,发送的数据直接暂存
+	retryAt time.Time
 }
 
-func (c *connReport) WriteSpan(sp *Span) error {
-	data, err := marshalSpan(sp, c.version)
+func (c *connReport) WriteSpan(sd *SpanData) error {
+	marshal := marshalSpan
+	if c.resources != nil {
+		c.resources.add(sd)
+		marshal = marshalFramedSpan
+	}
+	data, truncated, err := truncateSpan(sd, maxPackageSize, func(sd *SpanData) ([]byte, error) {
+		return marshal(sd, c.version)
+	})
 	if err != nil {
+		atomic.AddInt64(&c.batch.stats.marshalErrors, 1)
 		return err
 	}
+	if truncated {
+		atomic.AddInt64(&c.batch.stats.truncated, 1)
+	}
 	return c.writePackage(data)
 }
 
 func (c *connReport) writePackage(data []byte) error {
-	c.rmx.RLock()
-	defer c.rmx.RUnlock()
-	if c.closed {
-		return fmt.Errorf("report already closed")
-	}
 	if len(data) > maxPackageSize {
+		atomic.AddInt64(&c.batch.stats.droppedTooLarge, 1)
 		return fmt.Errorf("package too large length %d > %d", len(data), maxPackageSize)
 	}
-	select {
-	case c.dataCh <- data:
-		return nil
-	case <-time.After(defaultWriteChannelTimeout):
-		return fmt.Errorf("write to data channel timeout")
-	}
+	return c.batch.write(data)
 }
 
+// Close 最多等待 1 秒发送队列中的数据,不等待连接恢复,暂存在内存中的数据会丢失.
 func (c *connReport) Close() error {
-	c.rmx.Lock()
-	c.closed = true
-	c.rmx.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return c.shutdown(ctx, false)
+}
+
+// Flush 立即发送队列中的数据,连接不可用时按退避时间重试,直到暂存的数据全部发送或者 ctx 结束.
+func (c *connReport) Flush(ctx context.Context) error {
+	return c.batch.flushContext(ctx)
+}
+
+// Shutdown 停止接收数据,与 Flush 一样等待全部数据发送完成或者 ctx 结束后关闭连接.
+func (c *connReport) Shutdown(ctx context.Context) error {
+	return c.shutdown(ctx, true)
+}
 
-	t := time.NewTimer(time.Second)
-	close(c.dataCh)
-	select {
-	case <-t.C:
-		c.closeConn()
-		return fmt.Errorf("close report timeout force close")
-	case <-c.done:
-		return c.closeConn()
+func (c *connReport) shutdown(ctx context.Context, wait bool) error {
+	// 超时时同样等待 daemon 退出,之后由当前协程访问队列与连接
+	err := c.batch.shutdown(ctx)
+	if err == errReportClosed {
+		return err
+	}
+	if err == nil && wait {
+		err = c.drainContext(ctx)
+	}
+	// 内存中暂存的数据在关闭后丢失
+	if q, ok := c.queue.(*memQueue); ok {
+		for _, item := range q.items {
+			atomic.AddInt64(&c.batch.stats.failed, int64(item.spans))
+		}
 	}
+	c.queue.Close()
+	if cerr := c.closeConn(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
-func (c *connReport) send(data []byte) {
-	if c.conn == nil {
-		if err := c.reconnect(); err != nil {
-			c.Errorf("connect error: %s retry after second", err)
-			time.Sleep(time.Second)
+// Stats 返回上报器的统计数据.
+func (c *connReport) Stats() ReportStats {
+	return c.batch.Stats()
+}
+
+func (c *connReport) flush(ctx context.Context, batch [][]byte) {
+	if c.batched {
+		data := encodeBatch(c.version, batch)
+		if c.compressor != nil {
+			data = c.compressor.compressFrames(c.version, data)
+		}
+		c.send(ctx, data, len(batch))
+		return
+	}
+	for _, data := range batch {
+		if c.framed {
+			data = appendFrame(make([]byte, 0, frameSize(len(data))), c.version, 0, data)
+		}
+		c.send(ctx, data, 1)
+	}
+}
+
+// send 先重发暂存的数据以保证顺序,连接不可用或者 ctx 结束时暂存数据.
+func (c *connReport) send(ctx context.Context, data []byte, spans int) {
+	if c.drain(ctx); c.queue.empty() && ctx.Err() == nil {
+		if err := c.write(ctx, data); err == nil {
+			atomic.AddInt64(&c.batch.stats.sent, int64(spans))
+			return
+		}
+	}
+	dropped, err := c.queue.push(data, spans)
+	if err != nil {
+		c.Errorf("queue data error: %s", err)
+	}
+	if dropped > 0 {
+		atomic.AddInt64(&c.batch.stats.failed, int64(dropped))
+		c.Errorf("queue exceeds max size, drop %d spans", dropped)
+	}
+	atomic.StoreInt64(&c.batch.stats.buffered, c.queue.size())
+}
+
+// drain 按顺序重发暂存的数据,遇到失败或者 ctx 结束时停止,等待退避时间后再次尝试.
+func (c *connReport) drain(ctx context.Context) {
+	for !c.queue.empty() && !time.Now().Before(c.retryAt) && ctx.Err() == nil {
+		data, spans, err := c.queue.peek()
+		if err != nil {
+			c.Errorf("read queue error: %s", err)
+			return
+		}
+		if data == nil {
+			return
+		}
+		if err := c.write(ctx, data); err != nil {
+			return
+		}
+		atomic.AddInt64(&c.batch.stats.sent, int64(spans))
+		err = c.queue.pop()
+		atomic.StoreInt64(&c.batch.stats.buffered, c.queue.size())
+		if err != nil {
+			c.Errorf("remove queue data error: %s", err)
 			return
 		}
 	}
-	c.conn.SetWriteDeadline(time.Now().Add(100 * time.Microsecond))
+}
+
+// drainContext 重发暂存的数据直到全部发送成功,连接不可用时等待退避时间后重试,ctx 结束时返回错误.
+func (c *connReport) drainContext(ctx context.Context) error {
+	for {
+		if c.drain(ctx); c.queue.empty() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%d bytes not sent: %w", c.queue.size(), err)
+		}
+		if !time.Now().Before(c.retryAt) {
+			// 没有进入退避状态,说明读取暂存数据失败,重试没有意义
+			return fmt.Errorf("%d bytes not sent: read queue error", c.queue.size())
+		}
+		t := time.NewTimer(time.Until(c.retryAt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%d bytes not sent: %w", c.queue.size(), ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// write 将数据写入连接,之前先写入连接上尚未写入的 Resource 帧,写入超时不超过 ctx 的截止时间,失败时关闭连接并进入退避状态.
+func (c *connReport) write(ctx context.Context, data []byte) error {
+	if time.Now().Before(c.retryAt) {
+		return fmt.Errorf("waiting for retry")
+	}
+	if c.conn == nil {
+		if err := c.reconnect(ctx); err != nil {
+			c.disconnect("connect error: %s", err)
+			return err
+		}
+		atomic.AddInt64(&c.batch.stats.reconnects, 1)
+		c.announced = 0
+	}
+	var announced int
+	if c.resources != nil {
+		from := c.announced
+		if !isStreamNetwork(c.network) {
+			// 数据报之间相互独立,每个包都写入全部 Resource 帧
+			from = 0
+		}
+		var frames []byte
+		if frames, announced = c.resources.appendFrames(nil, from); len(frames) > 0 {
+			data = append(frames, data...)
+		}
+	}
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetWriteDeadline(deadline)
 	if _, err := c.conn.Write(data); err != nil {
-		c.Errorf("write to conn error: %s, close connect", err)
 		c.conn.Close()
 		c.conn = nil
+		c.disconnect("write to conn error: %s, close connect", err)
+		return err
 	}
+	c.announced = announced
+	c.backoff.reset()
+	atomic.StoreInt32(&c.state, int32(StateConnected))
+	return nil
+}
+
+func (c *connReport) disconnect(format string, err error) {
+	delay := c.backoff.next()
+	c.retryAt = time.Now().Add(delay)
+	atomic.StoreInt32(&c.state, int32(StateBackoff))
+	c.Errorf(format+", retry after %s", err, delay)
+}
+
+// State 返回当前的连接状态.
+func (c *connReport) State() ConnState {
+	return ConnState(atomic.LoadInt32(&c.state))
 }
 
-func (c *connReport) reconnect() (err error) {
-	c.conn, err = net.DialTimeout(c.network, c.address, c.timeout)
+func (c *connReport) reconnect(ctx context.Context) (err error) {
+	if c.dialErr != nil {
+		return c.dialErr
+	}
+	dialer := &net.Dialer{Timeout: c.timeout}
+	if c.tlsConfig != nil {
+		// 超时时间包含 TLS 握手
+		c.conn, err = (&tls.Dialer{NetDialer: dialer, Config: c.tlsConfig}).DialContext(ctx, c.network, c.address)
+		return
+	}
+	c.conn, err = dialer.DialContext(ctx, c.network, c.address)
 	return
 }
 
@@ -135,5 +365,178 @@ func (c *connReport) closeConn() error {
 }
 
 func (c *connReport) Errorf(format string, args ...interface{}) {
+	errorf(format, args...)
+}
+
+// ConnState 上报器的连接状态.
+type ConnState int32
+
+const (
+	// StateConnecting 尚未建立过连接
+	StateConnecting ConnState = iota
+	// StateConnected 连接正常,最近一次写入成功
+	StateConnected
+	// StateBackoff 连接失败,等待退避时间后重试,期间数据暂存在队列中
+	StateBackoff
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBackoff:
+		return "backoff"
+	default:
+		return "unknown"
+	}
+}
+
+// ReportState 返回全局tracer上报器的连接状态,上报器不支持时ok为false.
+func ReportState() (state ConnState, ok bool) {
+	d, ok := _tracer.(*dapper)
+	if !ok {
+		return
+	}
+	r, ok := d.reporter.(interface{ State() ConnState })
+	if !ok {
+		return
+	}
+	return r.State(), true
+}
+
+// backoff 带抖动的指数退避,每次失败后等待时间翻倍,实际等待时间在 [d/2, d) 之间随机.
+type backoff struct {
+	min, max time.Duration
+	attempts int
+}
+
+func (b *backoff) next() time.Duration {
+	d := b.min << uint(b.attempts)
+	if d > b.max || d <= 0 {
+		d = b.max
+	} else {
+		b.attempts++
+	}
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func (b *backoff) reset() {
+	b.attempts = 0
+}
+
+// sendQueue 连接不可用时暂存待发送数据的队列.
+type sendQueue interface {
+	empty() bool
+	// push 追加包含 spans 个 span 的数据,返回因超过大小上限而丢弃的 span 数量
+	push(data []byte, spans int) (dropped int, err error)
+	// peek 返回最早的数据以及其中的 span 数量,但不移除
+	peek() (data []byte, spans int, err error)
+	pop() error
+	// size 返回暂存数据的总字节数
+	size() int64
+	Close() error
+}
+
+// queueItem 暂存的一次写入的数据以及其中的 span 数量.
+type queueItem struct {
+	data  []byte
+	spans int
+}
+
+// memQueue 内存中的 sendQueue,超过大小上限时丢弃最早的数据.
+type memQueue struct {
+	maxSize int64
+	total   int64
+	items   []queueItem
+}
+
+func newMemQueue(maxSize int64) *memQueue {
+	return &memQueue{maxSize: maxSize}
+}
+
+func (m *memQueue) empty() bool {
+	return len(m.items) == 0
+}
+
+func (m *memQueue) push(data []byte, spans int) (dropped int, err error) {
+	m.items = append(m.items, queueItem{data: data, spans: spans})
+	m.total += int64(len(data))
+	for m.total > m.maxSize && len(m.items) > 1 {
+		dropped += m.items[0].spans
+		m.pop()
+	}
+	return
+}
+
+func (m *memQueue) peek() ([]byte, int, error) {
+	if len(m.items) == 0 {
+		return nil, 0, nil
+	}
+	return m.items[0].data, m.items[0].spans, nil
+}
+
+func (m *memQueue) pop() error {
+	m.total -= int64(len(m.items[0].data))
+	m.items[0] = queueItem{}
+	m.items = m.items[1:]
+	return nil
+}
+
+func (m *memQueue) size() int64 {
+	return m.total
+}
+
+func (m *memQueue) Close() error {
+	return nil
+}
+
+// flushReporter 上报器支持时等待其队列中的数据发送完毕.
+func flushReporter(ctx context.Context, r reporter) error {
+	if f, ok := r.(interface{ Flush(context.Context) error }); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+// shutdownReporter 上报器支持时在 ctx 结束前关闭,否则直接调用 Close.
+func shutdownReporter(ctx context.Context, r reporter) error {
+	if s, ok := r.(interface{ Shutdown(context.Context) error }); ok {
+		return s.Shutdown(ctx)
+	}
+	return r.Close()
+}
+
+// errorf 上报器内部错误输出到标准错误.
+func errorf(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format+"\n", args...)
 }
+
+// newReporter 根据 Config.Network 创建对应的上报器,配置了 Reporters 时同时上报到多个目标.
+func newReporter(cfg *Config) reporter {
+	if len(cfg.Reporters) > 0 {
+		reporters := make([]reporter, len(cfg.Reporters))
+		for i, c := range cfg.Reporters {
+			reporters[i] = newReporter(c)
+		}
+		return newMultiReport(reporters...)
+	}
+	switch cfg.Network {
+	case "zipkin":
+		return newZipkinReport(cfg)
+	case "jaeger":
+		return newJaegerReport(cfg)
+	case "otlp":
+		return newOTLPReport(cfg)
+	case "otlpgrpc":
+		return newOTLPGRPCReport(cfg)
+	case "file":
+		return newFileReport(cfg)
+	case "console":
+		return newConsoleReport(consoleWriter(cfg.Addr))
+	default:
+		return newConnReport(cfg)
+	}
+}
@@ -2,12 +2,16 @@ package trace
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/aluka-7/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,6 +53,28 @@ func TestReportTCP(t *testing.T) {
 	assert.Equal(t, data, buf.Bytes(), "receive data")
 }
 
+func TestReportDefaultVersion(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cancel, err := newServer(buf, "tcp", "127.0.0.1:6090")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockReport{}
+	NewTracer("service1", nil, mock, true).New("opt").Finish(nil)
+	// 未配置 protocol_version 时按 v1 编码,不使用帧格式
+	report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6090"})
+	assert.Equal(t, protoVersion1, report.version)
+	assert.False(t, report.framed)
+	assert.Nil(t, report.WriteSpan(mock.sps[0]))
+	if err := report.Close(); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	sd, err := UnmarshalSpan(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, "opt", sd.OperationName)
+}
+
 func newUnixgramServer(w io.Writer, address string) (func() error, error) {
 	conn, err := net.ListenPacket("unixgram", address)
 	if err != nil {
@@ -86,3 +112,123 @@ func TestReportUnixgram(t *testing.T) {
 	cancel()
 	assert.Equal(t, data, buf.Bytes(), "receive data")
 }
+
+func TestBackoff(t *testing.T) {
+	b := backoff{min: 100 * time.Millisecond, max: time.Second}
+	for _, d := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		d *= time.Millisecond
+		delay := b.next()
+		assert.True(t, delay >= d/2 && delay <= d, "delay %s out of [%s, %s]", delay, d/2, d)
+	}
+	b.reset()
+	assert.True(t, b.next() <= 100*time.Millisecond)
+}
+
+func TestMemQueue(t *testing.T) {
+	q := newMemQueue(10)
+	q.push([]byte("data0"), 2)
+	q.push([]byte("data1"), 1)
+	dropped, _ := q.push([]byte("data2"), 1)
+	assert.Equal(t, 2, dropped)
+	assert.Equal(t, int64(10), q.size())
+	data, spans, _ := q.peek()
+	assert.Equal(t, []byte("data1"), data)
+	assert.Equal(t, 1, spans)
+	q.pop()
+	q.pop()
+	assert.True(t, q.empty())
+}
+
+func TestReportReconnect(t *testing.T) {
+	report := newConnReport(&Config{
+		Network:       "tcp",
+		Addr:          "127.0.0.1:6081",
+		FlushInterval: utils.Duration(10 * time.Millisecond),
+	})
+	report.backoff = backoff{min: 10 * time.Millisecond, max: 20 * time.Millisecond}
+	assert.Equal(t, StateConnecting, report.State())
+	for i := 0; i < 3; i++ {
+		report.writePackage([]byte(fmt.Sprintf("data%d", i)))
+	}
+	// 目标不可用时数据暂存在内存中
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, StateBackoff, report.State())
+
+	buf := &bytes.Buffer{}
+	cancel, err := newServer(buf, "tcp", "127.0.0.1:6081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, StateConnected, report.State())
+	report.writePackage([]byte("data3"))
+	if err := report.Close(); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	assert.Equal(t, "data0data1data2data3", buf.String())
+}
+
+func TestReportFlush(t *testing.T) {
+	t.Run("test flush waits for reconnect", func(t *testing.T) {
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6086", BatchSize: 16, FlushInterval: utils.Duration(time.Minute)})
+		report.backoff = backoff{min: 10 * time.Millisecond, max: 20 * time.Millisecond}
+		report.writePackage([]byte("hello"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, report.Flush(ctx), context.DeadlineExceeded)
+
+		buf := &bytes.Buffer{}
+		stop, err := newServer(buf, "tcp", "127.0.0.1:6086")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.Nil(t, report.Flush(ctx))
+		assert.Equal(t, StateConnected, report.State())
+		assert.Nil(t, report.Shutdown(ctx))
+		stop()
+		_, _, payload, err := readFrame(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("hello"), payload)
+	})
+	t.Run("test shutdown timeout", func(t *testing.T) {
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6088", BatchSize: 16, FlushInterval: utils.Duration(time.Minute)})
+		report.writePackage([]byte("hello"))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, report.Shutdown(ctx), context.DeadlineExceeded)
+		// 暂存在内存中的数据计入失败
+		assert.Equal(t, int64(1), report.Stats().Failed)
+		assert.Nil(t, report.conn)
+	})
+	t.Run("test global shutdown", func(t *testing.T) {
+		old := _tracer
+		defer func() { _tracer = old }()
+		buf := &bytes.Buffer{}
+		stop, err := newServer(buf, "tcp", "127.0.0.1:6087")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r1 := &syncReport{}
+		report := newMultiReport(r1, newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6087", ProtocolVersion: protoVersion1, BatchSize: 16, FlushInterval: utils.Duration(time.Minute)}))
+		_tracer = NewTracer("service1", nil, report, true)
+		for i := 0; i < 3; i++ {
+			New("opt_shutdown").Finish(nil)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.Nil(t, Flush(ctx))
+		r1.mu.Lock()
+		assert.Len(t, r1.sps, 3)
+		r1.mu.Unlock()
+		assert.Nil(t, Shutdown(ctx))
+		stop()
+		spans, err := UnmarshalBatch(buf.Bytes())
+		assert.Nil(t, err)
+		assert.Len(t, spans, 3)
+		assert.NotNil(t, report.Shutdown(ctx), "shutdown twice")
+	})
+}
@@ -0,0 +1,196 @@
+package trace
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	protogen "github.com/aluka-7/trace/proto"
+)
+
+// Resource 中自动填充的进程属性,命名与 OpenTelemetry 的语义约定一致.
+const (
+	// ResourceHostName 主机名
+	ResourceHostName = "host.name"
+	// ResourceProcessPID 进程ID
+	ResourceProcessPID = "process.pid"
+	// ResourceExecutableName 可执行文件名
+	ResourceExecutableName = "process.executable.name"
+	// ResourceRuntimeName 运行时名称,固定为go
+	ResourceRuntimeName = "process.runtime.name"
+	// ResourceRuntimeVersion Go 版本
+	ResourceRuntimeVersion = "process.runtime.version"
+	// ResourceServiceVersion 主模块的版本,go build 时写入的 build info
+	ResourceServiceVersion = "service.version"
+	// ResourceVCSRevision 构建时的代码版本
+	ResourceVCSRevision = "vcs.revision"
+	// ResourceEnv 部署环境,见 Config.Env
+	ResourceEnv = "deployment.environment"
+)
+
+// Resource 描述产生 span 的进程.
+// 支持进程级属性的上报器(jaeger,otlp,otlpgrpc)每个批量包只上报一次;
+// 帧格式的 protobuf 数据(批量上报,v2 的流式网络以及 protobuf 格式的文件)将其编码为 Resource 帧,
+// 流式网络的每个连接以及每个文件只写入一次,数据报的每个批量包写入一次,见 resourceFrames.
+// 其它编码(v1 非批量的 protobuf,zipkin,span JSON,console)没有对应的结构,写入 span 的 env,
+// 并与之前一样在每个 span 中写入 NewTracer 传入的 tag,见 SpanData.spanTags.
+type Resource struct {
+	ServiceName string
+	Env         string
+	// Tags 自动填充的进程属性以及 NewTracer 传入的 tag,同名时以传入的 tag 为准
+	Tags []Tag
+	// tags NewTracer 传入的 tag
+	tags []Tag
+}
+
+// newResource 创建描述当前进程的 Resource.
+func newResource(serviceName, env string, tags []Tag) *Resource {
+	attrs := processAttributes()
+	if env != "" {
+		attrs = append(attrs, TagString(ResourceEnv, env))
+	}
+	r := &Resource{ServiceName: serviceName, Env: env}
+	for _, attr := range attrs {
+		if !hasTag(tags, attr.Key) {
+			r.Tags = append(r.Tags, attr)
+		}
+	}
+	r.Tags = append(r.Tags, tags...)
+	for _, tag := range tags {
+		r.tags = append(r.tags, Tag{Key: tag.Key, Value: normalizeValue(tag.Value)})
+	}
+	return r
+}
+
+// spanTags 返回写入每个 span 的 tag,NewTracer 传入的 tag 在前,用于没有进程级属性的编码.
+func (sd *SpanData) spanTags() []Tag {
+	if sd.Resource == nil || len(sd.Resource.tags) == 0 {
+		return sd.Tags
+	}
+	tags := make([]Tag, 0, len(sd.Resource.tags)+len(sd.Tags))
+	tags = append(tags, sd.Resource.tags...)
+	return append(tags, sd.Tags...)
+}
+
+// resourceFrames 按服务首次出现的顺序记录编码为 Resource 帧的 Resource.
+// add 可以在多个协程中调用,上报器在 daemon 协程中将尚未写入的帧写在 span 之前.
+type resourceFrames struct {
+	version int32
+	mu      sync.Mutex
+	frames  [][]byte
+	index   map[string]bool
+}
+
+func newResourceFrames(version int32) *resourceFrames {
+	return &resourceFrames{version: version, index: make(map[string]bool)}
+}
+
+// add 记录 span 所属服务的 Resource,每个服务只编码一次,解码得到的 SpanData 没有 Resource,忽略.
+func (r *resourceFrames) add(sd *SpanData) {
+	if sd.Resource == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.index[sd.ServiceName] {
+		return
+	}
+	r.index[sd.ServiceName] = true
+	r.frames = append(r.frames, encodeResourceFrame(r.version, sd.ServiceName, sd.Resource))
+}
+
+// appendFrames 将第 from 个之后的 Resource 帧追加到 buf,返回追加后的 buf 与帧的总数.
+// 流式的数据中记录已经写入的数量,之后只需要写入新出现的服务.
+func (r *resourceFrames) appendFrames(buf []byte, from int) ([]byte, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, frame := range r.frames[from:] {
+		buf = append(buf, frame...)
+	}
+	return buf, len(r.frames)
+}
+
+// encodeResourceFrame 将 Resource 编码为带有 frameFlagResource 标记的帧,tag 的编码与 span 相同.
+func encodeResourceFrame(version int32, service string, r *Resource) []byte {
+	ps := &protogen.Span{Version: version, ServiceName: service, Env: r.Env, Tags: make([]*protogen.Tag, len(r.Tags))}
+	for i, tag := range r.Tags {
+		if version == protoVersion1 {
+			ps.Tags[i] = toProtoTag(tag)
+		} else {
+			ps.Tags[i] = toProtoTagV2(tag)
+		}
+	}
+	data, _ := proto.Marshal(ps)
+	return appendFrame(make([]byte, 0, frameSize(len(data))), version, frameFlagResource, data)
+}
+
+var (
+	processOnce  sync.Once
+	processAttrs []Tag
+)
+
+// processAttributes 返回当前进程的属性,只在首次调用时读取.
+func processAttributes() []Tag {
+	processOnce.Do(func() {
+		if hostname, err := os.Hostname(); err == nil {
+			processAttrs = append(processAttrs, TagString(ResourceHostName, hostname))
+		}
+		processAttrs = append(processAttrs,
+			TagInt64(ResourceProcessPID, int64(os.Getpid())),
+			TagString(ResourceExecutableName, filepath.Base(os.Args[0])),
+			TagString(ResourceRuntimeName, "go"),
+			TagString(ResourceRuntimeVersion, runtime.Version()),
+		)
+		if info, ok := debug.ReadBuildInfo(); ok {
+			if version := info.Main.Version; version != "" && version != "(devel)" {
+				processAttrs = append(processAttrs, TagString(ResourceServiceVersion, version))
+			}
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					processAttrs = append(processAttrs, TagString(ResourceVCSRevision, setting.Value))
+				}
+			}
+		}
+	})
+	return append([]Tag(nil), processAttrs...)
+}
+
+func hasTag(tags []Tag, key string) bool {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceCache 按服务名缓存编码后的 Resource,批量上报时每个服务只编码一次.
+type resourceCache struct {
+	encode func(service string, r *Resource) []byte
+	m      sync.Map
+}
+
+func newResourceCache(encode func(service string, r *Resource) []byte) *resourceCache {
+	return &resourceCache{encode: encode}
+}
+
+// get 返回 span 所属服务编码后的 Resource,首次出现的服务使用 span 的 Resource 编码.
+func (c *resourceCache) get(sd *SpanData) []byte {
+	if data, ok := c.m.Load(sd.ServiceName); ok {
+		return data.([]byte)
+	}
+	data, _ := c.m.LoadOrStore(sd.ServiceName, c.encode(sd.ServiceName, sd.Resource))
+	return data.([]byte)
+}
+
+// load 返回服务编码后的 Resource,没有记录时只包含服务名.
+func (c *resourceCache) load(service string) []byte {
+	if data, ok := c.m.Load(service); ok {
+		return data.([]byte)
+	}
+	return c.encode(service, nil)
+}
@@ -0,0 +1,172 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/utils"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	protogen "github.com/aluka-7/trace/proto"
+)
+
+func TestResource(t *testing.T) {
+	t.Run("test process attributes", func(t *testing.T) {
+		r := newResource("service1", "uat", []Tag{TagString("ip", "10.0.0.1"), TagString(ResourceRuntimeName, "tinygo")})
+		assert.Equal(t, "service1", r.ServiceName)
+		assert.Equal(t, "uat", r.Env)
+		tags := make(map[string]interface{})
+		for _, tag := range r.Tags {
+			_, dup := tags[tag.Key]
+			assert.False(t, dup, tag.Key)
+			tags[tag.Key] = tag.Value
+		}
+		assert.Equal(t, int64(os.Getpid()), tags[ResourceProcessPID])
+		assert.Equal(t, runtime.Version(), tags[ResourceRuntimeVersion])
+		assert.Equal(t, "uat", tags[ResourceEnv])
+		assert.Equal(t, "10.0.0.1", tags["ip"])
+		// 传入的 tag 覆盖自动填充的属性
+		assert.Equal(t, "tinygo", tags[ResourceRuntimeName])
+		assert.NotContains(t, newResource("service1", "", nil).Tags, TagString(ResourceEnv, ""))
+	})
+	t.Run("test not appended to spans", func(t *testing.T) {
+		report := &mockReport{}
+		t1 := NewTracer("service1", []Tag{TagString("ip", "10.0.0.1")}, report, true)
+		t1.New("opt").Finish(nil)
+		sd := report.sps[0]
+		assert.Equal(t, []Tag{TagString(TagSpanKind, "server")}, sd.Tags)
+		assert.True(t, sd.Resource == t1.(*dapper).resource)
+	})
+	t.Run("test tracer tags in span encodings", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		stop, err := newServer(buf, "tcp", "127.0.0.1:6089")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock := &mockReport{}
+		t1 := NewTracer("service1", []Tag{TagString("ip", "10.0.0.1")}, mock, true)
+		t1.New("opt").Finish(nil)
+		sd := mock.sps[0]
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6089", ProtocolVersion: protoVersion1})
+		assert.Nil(t, report.WriteSpan(sd))
+		assert.Nil(t, report.Close())
+		stop()
+		ps := new(protogen.Span)
+		if err := proto.Unmarshal(buf.Bytes(), ps); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "ip", ps.Tags[0].Key)
+		assert.Equal(t, []byte("10.0.0.1"), ps.Tags[0].Value)
+
+		assert.Equal(t, "10.0.0.1", toZipkinSpan(sd).Tags["ip"])
+		data, err := json.Marshal(sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := UnmarshalSpanJSON(data)
+		assert.Nil(t, err)
+		assert.Equal(t, TagString("ip", "10.0.0.1"), decoded.Tags[0])
+	})
+	t.Run("test otlp resource", func(t *testing.T) {
+		c := newResourceCache(encodeOTLPResource)
+		assert.Len(t, decodeProto(t, c.load("service1")).attributes(t, 1), 1)
+		sd := &SpanData{ServiceName: "service1", Resource: newResource("service1", "uat", []Tag{TagString("ip", "10.0.0.1")})}
+		c.get(sd)
+		attrs := decodeProto(t, c.load("service1")).attributes(t, 1)
+		assert.Equal(t, "service1", attrs["service.name"].string(1))
+		assert.Equal(t, "10.0.0.1", attrs["ip"].string(1))
+		assert.Equal(t, "uat", attrs[ResourceEnv].string(1))
+	})
+	t.Run("test resource frame once per connection", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		stop, err := newServer(buf, "tcp", "127.0.0.1:6091")
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6091", ProtocolVersion: protoVersion2, BatchSize: 16, FlushInterval: utils.Duration(time.Minute)})
+		t1 := NewTracer("service1", []Tag{TagString("ip", "10.0.0.1")}, report, true)
+		for i := 0; i < 2; i++ {
+			t1.New("opt").Finish(nil)
+			assert.Nil(t, report.Flush(context.Background()))
+		}
+		assert.Nil(t, report.Close())
+		stop()
+
+		resources := 0
+		for r := bytes.NewReader(buf.Bytes()); r.Len() > 0; {
+			_, flags, _, err := readFrame(r)
+			assert.Nil(t, err)
+			if flags&frameFlagResource != 0 {
+				resources++
+			}
+		}
+		assert.Equal(t, 1, resources)
+		r := NewSpanReader(bytes.NewReader(buf.Bytes()))
+		for i := 0; i < 2; i++ {
+			sp, err := r.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Len(t, sp.Tags, 1)
+			assert.Equal(t, TagSpanKind, sp.Tags[0].Key)
+		}
+		resource := r.Resource("service1")
+		if assert.NotNil(t, resource) {
+			tags := make(map[string]string)
+			for _, tag := range resource.Tags {
+				tags[tag.Key] = string(tag.Value)
+			}
+			assert.Equal(t, "10.0.0.1", tags["ip"])
+			assert.Equal(t, "go", tags[ResourceRuntimeName])
+		}
+	})
+	t.Run("test resource frame in every datagram", func(t *testing.T) {
+		os.Remove("/tmp/trace_resource.sock")
+		buf := &bytes.Buffer{}
+		stop, err := newUnixgramServer(buf, "/tmp/trace_resource.sock")
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := newConnReport(&Config{Network: "unixgram", Addr: "/tmp/trace_resource.sock", BatchSize: 16, FlushInterval: utils.Duration(time.Minute)})
+		NewTracer("service1", nil, report, true).New("opt").Finish(nil)
+		assert.Nil(t, report.Close())
+		stop()
+		_, flags, _, err := readFrame(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, frameFlagResource, flags)
+		spans, err := UnmarshalBatch(buf.Bytes())
+		assert.Nil(t, err)
+		assert.Len(t, spans, 1)
+	})
+	t.Run("test resource frame in protobuf file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "spans.bin")
+		report := newFileReport(&Config{Addr: path, Format: fileFormatProtobuf})
+		t1 := NewTracer("service1", []Tag{TagString("ip", "10.0.0.1")}, report, true)
+		t1.New("opt").Finish(nil)
+		t2 := NewTracer("service2", nil, report, true)
+		t2.New("opt").Finish(nil)
+		assert.Nil(t, report.Close())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewSpanReader(bytes.NewReader(data))
+		for _, service := range []string{"service1", "service2"} {
+			sp, err := r.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, service, sp.ServiceName)
+			assert.NotNil(t, r.Resource(service))
+		}
+		assert.Equal(t, "ip", r.Resource("service1").Tags[len(r.Resource("service1").Tags)-1].Key)
+	})
+}
@@ -2,6 +2,7 @@ package trace
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	protoGen "github.com/aluka-7/trace/proto"
@@ -11,27 +12,83 @@ const (
 	_maxChildren = 1024
 	_maxTags     = 128
 	_maxLogs     = 256
+	_maxLinks    = 128
 )
 
-var _ Trace = &Span{}
+var (
+	_ Trace  = &Span{}
+	_ Linker = &Span{}
+)
 
 // Span is a trace span.
+// 可以在多个 goroutine 中并发地派生子 span 以及写入 tag 和 log.
+// tags,logs 等数据保存在对象池中的 spanState 中,Finish 后 spanState 被回收复用,
+// 之后对该 span 的修改以及重复 Finish 都会被忽略,见 LateSpanCalls.
 type Span struct {
-	dapper        *dapper
-	context       spanContext
+	*spanState
+	// gen 创建时 spanState 的代数,与 spanState.gen 不同时说明 span 已经结束
+	gen    uint64
+	dapper *dapper
+	// serviceName 覆盖 tracer 的服务名,为空时使用 tracer 的服务名,见 WithServiceName
+	serviceName string
+	context     spanContext
+	startTime   time.Time
+	// operationName 与 duration 由 spanState.mu 保护,span 结束后不再修改
 	operationName string
-	startTime     time.Time
 	duration      time.Duration
-	tags          []Tag
-	logs          []*protoGen.Log
-	children      int
+}
+
+// spanState span 中会被修改的数据,由 mu 保护.
+type spanState struct {
+	mu sync.Mutex
+	// gen 每次 Finish 时加一,使持有该 spanState 的 Span 失效
+	gen  uint64
+	tags []Tag
+	logs []spanLog
+	// refType 与父 span 的关系,Follow 创建的 span 为 FOLLOWS_FROM
+	refType protoGen.SpanRef_RefType
+	// links Link 记录的引用
+	links    []SpanRef
+	children int
+}
+
+// spanLog SetLog 写入的一条 log,字段保留 LogInt64 等函数写入的原始值,v2 协议据此编码字段类型.
+type spanLog struct {
+	timestamp int64
+	fields    []LogField
+}
+
+// lock 锁定 spanState,span 已经结束时解锁并返回 false.
+func (s *Span) lock() bool {
+	s.mu.Lock()
+	if s.gen == s.spanState.gen {
+		return true
+	}
+	s.mu.Unlock()
+	return false
+}
+
+// lockLive 与 lock 相同,span 已经结束时记录一次对结束后 span 的调用.
+func (s *Span) lockLive(method string) bool {
+	if s.lock() {
+		return true
+	}
+	s.dapper.lateCall(method)
+	return false
 }
 
 func (s *Span) ServiceName() string {
+	if s.serviceName != "" {
+		return s.serviceName
+	}
 	return s.dapper.serviceName
 }
 
+// OperationName span 结束后仍然可以读取.
 func (s *Span) OperationName() string {
+	if s.lock() {
+		defer s.mu.Unlock()
+	}
 	return s.operationName
 }
 
@@ -39,7 +96,11 @@ func (s *Span) StartTime() time.Time {
 	return s.startTime
 }
 
+// Duration 返回 Finish 时记录的耗时,span 结束后仍然可以读取.
 func (s *Span) Duration() time.Duration {
+	if s.lock() {
+		defer s.mu.Unlock()
+	}
 	return s.duration
 }
 
@@ -51,78 +112,182 @@ func (s *Span) Context() spanContext {
 	return s.context
 }
 
+// Tags 返回 span 的 tag 的副本,span 结束后返回 nil.
 func (s *Span) Tags() []Tag {
-	return s.tags
+	if !s.lock() {
+		return nil
+	}
+	defer s.mu.Unlock()
+	return append([]Tag(nil), s.tags...)
 }
 
+// Logs 返回 span 的 log 的副本,span 结束后返回 nil.
 func (s *Span) Logs() []*protoGen.Log {
-	return s.logs
+	if !s.lock() {
+		return nil
+	}
+	defer s.mu.Unlock()
+	logs := make([]*protoGen.Log, len(s.logs))
+	for i, log := range s.logs {
+		fields := make([]*protoGen.Field, len(log.fields))
+		for j, field := range log.fields {
+			fields[j] = &protoGen.Field{Key: field.Key, Value: []byte(field.Value)}
+		}
+		logs[i] = &protoGen.Log{Timestamp: log.timestamp, Fields: fields}
+	}
+	return logs
 }
 
+// Fork 在 span 结束后仍然可以调用,例如在请求返回后继续运行的 goroutine 中派生子 span.
 func (s *Span) Fork(serviceName, operationName string) Trace {
-	if s.children > _maxChildren {
-		// if child span more than max children set return noopSpan
-		return noopSpan{}
+	if s.lock() {
+		if s.children > _maxChildren {
+			s.mu.Unlock()
+			// if child span more than max children set return noopSpan
+			return noopSpan{}
+		}
+		s.children++
+		s.mu.Unlock()
+	}
+	t := s.dapper.newSpanWithContext(operationName, s.context)
+	if sp, ok := t.(*Span); ok {
+		sp.serviceName = s.serviceName
 	}
-	s.children++
 	// 为了兼容临时为 New 的 Span 设置 span.kind
-	return s.dapper.newSpanWithContext(operationName, s.context).SetTag(TagString(TagSpanKind, "client"))
+	t.SetTag(TagString(TagSpanKind, "client"))
+	if serviceName != "" {
+		t.SetTag(TagString(TagPeerService, serviceName))
+	}
+	return t
 }
 
 func (s *Span) Follow(serviceName, operationName string) Trace {
-	return s.Fork(serviceName, operationName).SetTag(TagString(TagSpanKind, "producer"))
+	t := s.Fork(serviceName, operationName).SetTag(TagString(TagSpanKind, "producer"))
+	if sp, ok := t.(*Span); ok {
+		sp.refType = protoGen.SpanRef_FOLLOWS_FROM
+	}
+	return t
 }
 
+// Link 见 Link 函数.无法解析的 context 被忽略,返回第一个解析错误;span 已经结束时返回 errSpanFinished.
+func (s *Span) Link(contexts ...string) error {
+	var (
+		refs []SpanRef
+		err  error
+	)
+	for _, value := range contexts {
+		ctx, perr := contextFromString(value)
+		if perr == nil && !ctx.IsValid() {
+			perr = errInvalidTracerString
+		}
+		if perr != nil {
+			if err == nil {
+				err = fmt.Errorf("%w: %q", perr, value)
+			}
+			continue
+		}
+		if ctx.TraceId == s.context.TraceId && ctx.SpanId == s.context.SpanId {
+			continue
+		}
+		refs = append(refs, SpanRef{Type: RefFollowsFrom, TraceId: ctx.TraceId, SpanId: ctx.SpanId})
+	}
+	if !s.lockLive("Link") {
+		return errSpanFinished
+	}
+	defer s.mu.Unlock()
+	if !s.context.isSampled() && !s.context.isDebug() {
+		return err
+	}
+	for _, ref := range refs {
+		if len(s.links) >= _maxLinks {
+			break
+		}
+		s.links = append(s.links, ref)
+		if len(s.links) == _maxLinks {
+			s.setTag(Tag{Key: "trace.error", Value: "too many links"})
+		}
+	}
+	return err
+}
+
+// Finish 只有第一次调用有效,之后的调用被忽略.
 func (s *Span) Finish(perr *error) {
+	if !s.lockLive("Finish") {
+		return
+	}
+	var sd *SpanData
 	s.duration = time.Since(s.startTime)
-	if perr != nil && *perr != nil {
+	sampled := s.context.isSampled() || s.context.isDebug()
+	if sampled && perr != nil && *perr != nil {
 		err := *perr
-		s.SetTag(TagBool(TagError, true))
-		s.SetLog(Log(LogMessage, err.Error()))
+		s.setTag(TagBool(TagError, true))
+		s.setLogs(Log(LogMessage, err.Error()))
 		if err, ok := err.(stackTracer); ok {
-			s.SetLog(Log(LogStack, fmt.Sprintf("%+v", err.StackTrace())))
+			s.setLogs(Log(LogStack, fmt.Sprintf("%+v", err.StackTrace())))
 		}
 	}
-	s.dapper.report(s)
+	if s.context.isSampled() {
+		sd = newSpanData(s)
+	}
+	state := s.spanState
+	state.gen++
+	s.mu.Unlock()
+	s.dapper.report(state, sd)
 }
 
 func (s *Span) SetTag(tags ...Tag) Trace {
+	// 先检查 span 是否已经结束,未采样的 span 结束后的调用同样计入 LateSpanCalls
+	if !s.lockLive("SetTag") {
+		return s
+	}
 	if !s.context.isSampled() && !s.context.isDebug() {
+		s.mu.Unlock()
 		return s
 	}
+	s.setTag(tags...)
+	s.mu.Unlock()
+	return s
+}
+
+// setTag 调用方需要持有 s.mu.
+func (s *Span) setTag(tags ...Tag) {
 	if len(s.tags) < _maxTags {
 		s.tags = append(s.tags, tags...)
 	}
 	if len(s.tags) == _maxTags {
 		s.tags = append(s.tags, Tag{Key: "trace.error", Value: "too many tags"})
 	}
-	return s
 }
 
 // SetLog LogFields是一种有效且经过类型检查的方式来记录key:value
 // 注意:当前不支持
 func (s *Span) SetLog(logs ...LogField) Trace {
+	if !s.lockLive("SetLog") {
+		return s
+	}
 	if !s.context.isSampled() && !s.context.isDebug() {
+		s.mu.Unlock()
 		return s
 	}
+	s.setLogs(logs...)
+	s.mu.Unlock()
+	return s
+}
+
+// setLogs 调用方需要持有 s.mu.
+func (s *Span) setLogs(logs ...LogField) {
 	if len(s.logs) < _maxLogs {
 		s.setLog(logs...)
 	}
 	if len(s.logs) == _maxLogs {
 		s.setLog(LogField{Key: "trace.error", Value: "too many logs"})
 	}
-	return s
 }
 
 func (s *Span) setLog(logs ...LogField) Trace {
-	protoLog := &protoGen.Log{
-		Timestamp: time.Now().UnixNano(),
-		Fields:    make([]*protoGen.Field, len(logs)),
-	}
-	for i := range logs {
-		protoLog.Fields[i] = &protoGen.Field{Key: logs[i].Key, Value: []byte(logs[i].Value)}
-	}
-	s.logs = append(s.logs, protoLog)
+	fields := make([]LogField, len(logs))
+	copy(fields, logs)
+	s.logs = append(s.logs, spanLog{timestamp: time.Now().UnixNano(), fields: fields})
 	return s
 }
 
@@ -133,7 +298,11 @@ func (s *Span) Visit(fn func(k, v string)) {
 
 // SetTitle reset trace title
 func (s *Span) SetTitle(operationName string) {
+	if !s.lockLive("SetTitle") {
+		return
+	}
 	s.operationName = operationName
+	s.mu.Unlock()
 }
 
 func (s *Span) String() string {
@@ -1,8 +1,12 @@
 package trace
 
 import (
+	"bytes"
 	"fmt"
+	"log"
+	"net/http"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -22,6 +26,24 @@ func TestSpan(t *testing.T) {
 		sp2 := sp1.Fork("xxx", "opt_2").(*Span)
 		assert.Equal(t, sp1.context.TraceId, sp2.context.TraceId)
 		assert.Equal(t, sp1.context.SpanId, sp2.context.ParentId)
+		t.Run("test peer service", func(t *testing.T) {
+			assert.Contains(t, sp2.tags, TagString(TagPeerService, "xxx"))
+			sp3 := sp1.Fork("", "opt_3").(*Span)
+			for _, tag := range sp3.tags {
+				assert.NotEqual(t, TagPeerService, tag.Key)
+			}
+			assert.Equal(t, "service1", newSpanData(sp2).ServiceName)
+		})
+		t.Run("test service override", func(t *testing.T) {
+			sp3 := t1.New("testFork", WithServiceName("service_admin")).(*Span)
+			sp4 := sp3.Fork("redis", "GET").(*Span)
+			assert.Equal(t, "service_admin", sp3.ServiceName())
+			assert.Equal(t, "service_admin", newSpanData(sp4).ServiceName)
+			assert.Contains(t, sp4.tags, TagString(TagPeerService, "redis"))
+			sp4.Finish(nil)
+			sp3.Finish(nil)
+			assert.Equal(t, "service1", t1.New("reused").(*Span).ServiceName())
+		})
 		t.Run("test max fork", func(t *testing.T) {
 			sp3 := sp2.Fork("xx", "xxx")
 			for i := 0; i < 100; i++ {
@@ -61,8 +83,8 @@ func TestSpan(t *testing.T) {
 			assert.True(t, errorTag)
 			messageLog := false
 			for _, log := range sp1.logs {
-				assert.True(t, log.Timestamp != 0)
-				for _, field := range log.Fields {
+				assert.True(t, log.timestamp != 0)
+				for _, field := range log.fields {
 					if field.Key == LogMessage && len(field.Value) != 0 {
 						messageLog = true
 					}
@@ -78,7 +100,7 @@ func TestSpan(t *testing.T) {
 			sp1.Finish(&err)
 			ok := false
 			for _, log := range sp1.logs {
-				for _, field := range log.Fields {
+				for _, field := range log.fields {
 					if field.Key == LogStack && len(field.Value) != 0 {
 						ok = true
 					}
@@ -101,8 +123,221 @@ func TestSpan(t *testing.T) {
 				sp1.SetLog(LogField{Key: strconv.Itoa(i), Value: "hello"})
 			}
 			assert.Len(t, sp1.logs, _maxLogs+1)
-			assert.Equal(t, sp1.logs[_maxLogs].Fields[0].Key, "trace.error")
-			assert.Equal(t, sp1.logs[_maxLogs].Fields[0].Value, []byte("too many logs"))
+			assert.Equal(t, sp1.logs[_maxLogs].fields[0].Key, "trace.error")
+			assert.Equal(t, sp1.logs[_maxLogs].fields[0].Value, "too many logs")
+		})
+	})
+	t.Run("test link", func(t *testing.T) {
+		p1, p2 := t1.New("producer1").(*Span), t1.New("producer2").(*Span)
+		sp1 := t1.New("batch_consumer").(*Span)
+		err := Link(sp1, p1.TraceId(), p2.TraceId(), "invalid", sp1.TraceId())
+		assert.ErrorIs(t, err, errInvalidTracerString)
+		assert.Equal(t, []SpanRef{
+			{Type: RefFollowsFrom, TraceId: p1.context.TraceId, SpanId: p1.context.SpanId},
+			{Type: RefFollowsFrom, TraceId: p2.context.TraceId, SpanId: p2.context.SpanId},
+		}, sp1.links)
+
+		sp2 := sp1.Fork("", "opt_client").(*Span)
+		assert.Nil(t, sp2.Link(p1.TraceId()))
+		sd := newSpanData(sp2)
+		assert.Equal(t, []SpanRef{
+			{Type: RefChildOf, TraceId: sp1.context.TraceId, SpanId: sp1.context.SpanId},
+			{Type: RefFollowsFrom, TraceId: p1.context.TraceId, SpanId: p1.context.SpanId},
+		}, sd.References)
+		data, err := marshalSpan(sd, protoVersion2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := UnmarshalSpan(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, sd.References, decoded.References)
+
+		r := &compactReader{buf: encodeJaegerSpan(sd)}
+		refs := r.value(compactStruct).(map[int16]interface{})[6].([]interface{})
+		assert.Len(t, refs, 2)
+		assert.Equal(t, int64(RefFollowsFrom), refs[1].(map[int16]interface{})[1])
+		assert.Equal(t, int64(p1.context.SpanId), refs[1].(map[int16]interface{})[4])
+		links := decodeProto(t, appendOTLPSpan(nil, sd)).messages(t, 13)
+		assert.Len(t, links, 1)
+		assert.Equal(t, otlpSpanID(p1.context.SpanId), links[0][2][0])
+
+		sp2.Finish(nil)
+		assert.Empty(t, t1.New("reused").(*Span).links)
+		t.Run("test extracted context", func(t *testing.T) {
+			// 消息中携带的是生产者 span 的 context,Extract 返回的是新的子 span
+			header := make(http.Header)
+			assert.Nil(t, t1.Inject(p1, HTTPFormat, header))
+			consumer, err := t1.Extract(HTTPFormat, header)
+			assert.Nil(t, err)
+			batch := t1.New("batch_consumer")
+			assert.Nil(t, Link(batch, header.Get(SystemTraceID)))
+			assert.Equal(t, []SpanRef{{Type: RefFollowsFrom, TraceId: p1.context.TraceId, SpanId: p1.context.SpanId}}, batch.(*Span).links)
+			assert.NotEqual(t, p1.context.SpanId, consumer.(*Span).context.SpanId)
+		})
+		t.Run("test unsupported trace", func(t *testing.T) {
+			assert.Nil(t, Link(noopSpan{}, p1.TraceId()))
+			assert.Equal(t, ErrInvalidTrace, Link(struct{ Trace }{}, p1.TraceId()))
+		})
+		t.Run("test too many links", func(t *testing.T) {
+			sp1 := t1.New("batch_consumer").(*Span)
+			for i := 0; i < _maxLinks+10; i++ {
+				sp1.Link(p1.TraceId())
+			}
+			assert.Len(t, sp1.links, _maxLinks)
+			assert.Equal(t, []Tag{TagString(TagSpanKind, "server"), {Key: "trace.error", Value: "too many links"}}, sp1.tags)
 		})
 	})
 }
+
+func TestSpanConcurrent(t *testing.T) {
+	report := &syncReport{}
+	t1 := NewTracer("service1", nil, report, true)
+	sp1 := t1.New("opt_server").(*Span)
+	other := t1.New("opt_other")
+	const workers = 16
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sp2 := sp1.Fork("redis", "opt_client_"+strconv.Itoa(i))
+			sp1.SetTag(TagInt("worker", i))
+			sp1.SetLog(LogInt64("worker", int64(i)))
+			sp1.Link(other.TraceId())
+			sp1.SetTitle("opt_server")
+			sp2.SetTag(TagInt("worker", i))
+			sp2.SetLog(Log(LogEvent, "done"))
+			err := fmt.Errorf("worker %d", i)
+			sp2.Finish(&err)
+		}(i)
+	}
+	wg.Wait()
+	sp1.Finish(nil)
+
+	assert.Len(t, report.sps, workers+1)
+	sd := report.sps[workers]
+	assert.Equal(t, "opt_server", sd.OperationName)
+	assert.Len(t, sd.Tags, workers+1)
+	assert.Len(t, sd.Logs, workers)
+	assert.Len(t, sd.References, workers)
+	for _, child := range report.sps[:workers] {
+		assert.Equal(t, sd.SpanId, child.ParentId)
+		assert.Len(t, child.Logs, 2)
+	}
+}
+
+func TestSpanConcurrentMaxChildren(t *testing.T) {
+	t1 := NewTracer("service1", nil, &syncReport{}, true)
+	sp1 := t1.New("opt_server")
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		forks int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 256; j++ {
+				if _, ok := sp1.Fork("", "opt_client").(*Span); ok {
+					mu.Lock()
+					forks++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, _maxChildren+1, forks)
+}
+
+func TestSpanFinished(t *testing.T) {
+	report := &syncReport{}
+	t1 := NewTracer("service1", nil, report, true)
+	d := t1.(*dapper)
+	sp1 := t1.New("opt_server").(*Span)
+	sp1.Finish(nil)
+	sp1.Finish(nil)
+	assert.Len(t, report.sps, 1, "double finish reports once")
+	assert.Equal(t, int64(1), d.lateCalls)
+
+	// 回收的 spanState 可能已被新的 span 使用,结束后的修改不能影响新的 span
+	sp2 := t1.New("opt_other").(*Span)
+	sp1.SetTag(TagString("late", "tag"))
+	sp1.SetLog(Log("late", "log"))
+	assert.Equal(t, errSpanFinished, sp1.Link(sp2.TraceId()))
+	sp1.SetTitle("late")
+	assert.Equal(t, int64(5), d.lateCalls)
+	assert.Nil(t, sp1.Tags())
+	assert.Nil(t, sp1.Logs())
+	_, err := sp1.MarshalJSON()
+	assert.Equal(t, errSpanFinished, err)
+	assert.Equal(t, "opt_other", sp2.OperationName())
+	// 结束后读取的是该 span 自己的数据
+	assert.Equal(t, "opt_server", sp1.OperationName())
+	assert.True(t, sp1.Duration() > 0)
+	assert.Len(t, sp2.Tags(), 1)
+	assert.Empty(t, sp2.Logs())
+	// 返回的是副本
+	sp2.SetLog(Log("event", "retry"))
+	tags, logs := sp2.Tags(), sp2.Logs()
+	tags[0].Key = "changed"
+	logs[0].Fields[0].Key = "changed"
+	assert.Equal(t, TagSpanKind, sp2.Tags()[0].Key)
+	assert.Equal(t, "event", sp2.Logs()[0].Fields[0].Key)
+	sp2.Finish(nil)
+	assert.Len(t, report.sps[1].Tags, 1)
+	assert.Empty(t, report.sps[1].References)
+
+	// 结束后仍然可以使用 span 的上下文
+	sp3 := sp1.Fork("", "opt_client").(*Span)
+	assert.Equal(t, sp1.context.SpanId, sp3.context.ParentId)
+	assert.Equal(t, sp1.context.TraceId, sp3.context.TraceId)
+	header := make(http.Header)
+	assert.Nil(t, t1.Inject(sp1, HTTPFormat, header))
+	assert.Equal(t, sp1.String(), header.Get(SystemTraceID))
+	assert.Equal(t, int64(5), d.lateCalls)
+
+	// 未采样的 span 结束后的调用同样计入
+	unsampled := d.newSpanWithContext("opt_unsampled", spanContext{TraceId: 1, SpanId: 1}).(*Span)
+	unsampled.Finish(nil)
+	unsampled.SetTag(TagString("late", "tag"))
+	unsampled.SetLog(Log("late", "log"))
+	assert.Equal(t, errSpanFinished, unsampled.Link(sp2.TraceId()))
+	assert.Equal(t, int64(8), d.lateCalls)
+}
+
+func TestSpanFinishedStrict(t *testing.T) {
+	var buf bytes.Buffer
+	t1 := NewTracer("service1", nil, &syncReport{}, true, WithStrictSpan())
+	d := t1.(*dapper)
+	d.stdLog = log.New(&buf, "", 0)
+	sp1 := t1.New("opt_server")
+	sp1.Finish(nil)
+	sp1.SetTag(TagString("late", "tag"))
+	assert.Contains(t, buf.String(), "SetTag called on finished span")
+	assert.Contains(t, buf.String(), "TestSpanFinishedStrict")
+}
+
+func TestSpanConcurrentFinish(t *testing.T) {
+	report := &syncReport{}
+	t1 := NewTracer("service1", nil, report, true)
+	sp1 := t1.New("opt_server")
+	const workers = 16
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sp1.SetTag(TagString("worker", "done"))
+			sp1.Finish(nil)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, report.sps, 1)
+	// 除 span.kind 外每个 tag 都在 Finish 之前写入,其余的 SetTag 与 Finish 均被计数
+	setTags := len(report.sps[0].Tags) - 1
+	assert.Equal(t, int64(workers-setTags+workers-1), t1.(*dapper).lateCalls)
+}
@@ -0,0 +1,209 @@
+package trace
+
+import (
+	"encoding/binary"
+	errs "errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	protogen "github.com/aluka-7/trace/proto"
+)
+
+var errTagValue = errs.New("trace: invalid tag value")
+
+// RefType span 与被引用 span 的关系.
+type RefType int32
+
+const (
+	// RefChildOf 被引用的 span 为父 span
+	RefChildOf = RefType(protogen.SpanRef_CHILD_OF)
+	// RefFollowsFrom 被引用的 span 触发了当前 span,但不等待其结束,例如消息的生产者
+	RefFollowsFrom = RefType(protogen.SpanRef_FOLLOWS_FROM)
+)
+
+func (r RefType) String() string {
+	return protogen.SpanRef_RefType(r).String()
+}
+
+// SpanRef 对另一个 span 的引用.
+type SpanRef struct {
+	Type    RefType
+	TraceId uint64
+	SpanId  uint64
+}
+
+// LogData 一条 log,协议版本1中字段的值均为字符串.
+type LogData struct {
+	Timestamp time.Time
+	Fields    []Tag
+}
+
+// SpanData 已结束的 span 的数据,tag 与 log 字段的值为 string,int64,bool 或者 float64.
+// 上报器收到的 SpanData 是 span 结束时的快照,可以在 WriteSpan 返回后继续持有,但不能修改.
+type SpanData struct {
+	Version             int32
+	ServiceName         string
+	OperationName       string
+	TraceId             uint64
+	SpanId              uint64
+	ParentId            uint64
+	SamplingProbability float32
+	// Flags 采样与调试标记,只在本进程创建的 span 中有效
+	Flags      byte
+	Env        string
+	StartTime  time.Time
+	Duration   time.Duration
+	References []SpanRef
+	Tags       []Tag
+	Logs       []LogData
+	// Resource 产生 span 的进程,从 protobuf 或者 JSON 解码的 SpanData 中为空
+	Resource *Resource
+
+	// level span 在本进程内的层级,1 为本进程内的根 span
+	level int
+}
+
+func (sd *SpanData) isDebug() bool {
+	return (sd.Flags & flagDebug) == flagDebug
+}
+
+// UnmarshalSpan 解码 marshalSpan 生成的 protobuf 数据.
+func UnmarshalSpan(data []byte) (*SpanData, error) {
+	ps := new(protogen.Span)
+	if err := proto.Unmarshal(data, ps); err != nil {
+		return nil, err
+	}
+	return fromProtoSpan(ps)
+}
+
+// fromProtoSpan 按 ps.Version 将 protobuf 中编码的值转换为 Go 的值.
+func fromProtoSpan(ps *protogen.Span) (*SpanData, error) {
+	if ps.Version != protoVersion1 && ps.Version != protoVersion2 {
+		return nil, errSpanVersion
+	}
+	sd := &SpanData{
+		Version:             ps.Version,
+		ServiceName:         ps.ServiceName,
+		OperationName:       ps.OperationName,
+		TraceId:             ps.TraceId,
+		SpanId:              ps.SpanId,
+		ParentId:            ps.ParentId,
+		SamplingProbability: ps.SamplingProbability,
+		Env:                 ps.Env,
+	}
+	if ps.StartTime != nil {
+		sd.StartTime = time.Unix(ps.StartTime.Seconds, int64(ps.StartTime.Nanos))
+	}
+	if ps.Duration != nil {
+		sd.Duration = time.Duration(ps.Duration.Seconds)*time.Second + time.Duration(ps.Duration.Nanos)
+	}
+	for _, ref := range ps.References {
+		sd.References = append(sd.References, SpanRef{Type: RefType(ref.RefType), TraceId: ref.TraceId, SpanId: ref.SpanId})
+	}
+	sd.Tags = make([]Tag, len(ps.Tags))
+	for i, pTag := range ps.Tags {
+		value, err := parseTagValue(pTag.Kind, pTag.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: tag %s", err, pTag.Key)
+		}
+		sd.Tags[i] = Tag{Key: pTag.Key, Value: value}
+	}
+	sd.Logs = make([]LogData, len(ps.Logs))
+	for i, log := range ps.Logs {
+		ld := LogData{Timestamp: time.Unix(0, log.Timestamp), Fields: make([]Tag, len(log.Fields))}
+		for j, field := range log.Fields {
+			var value interface{} = string(field.Value)
+			if ps.Version == protoVersion2 {
+				// v2 中字段的值以类型开头,见 serializeLogValue
+				if len(field.Value) == 0 {
+					return nil, fmt.Errorf("%w: log field %s", errTagValue, field.Key)
+				}
+				var err error
+				if value, err = parseTagValue(protogen.Tag_Kind(field.Value[0]), field.Value[1:]); err != nil {
+					return nil, fmt.Errorf("%w: log field %s", err, field.Key)
+				}
+			}
+			ld.Fields[j] = Tag{Key: field.Key, Value: value}
+		}
+		sd.Logs[i] = ld
+	}
+	return sd, nil
+}
+
+// logValueString 返回 log 字段的值的字符串形式,与 LogInt64 等函数写入 LogField.Value 的值相同.
+func logValueString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// parseTagValue 是 serializeInt64,serializeBool,serializeFloat64 的逆过程.
+// v1 中浮点数的类型为 BOOL,按值的长度区分.
+func parseTagValue(kind protogen.Tag_Kind, value []byte) (interface{}, error) {
+	switch kind {
+	case protogen.Tag_STRING:
+		return string(value), nil
+	case protogen.Tag_INT:
+		if len(value) != 8 {
+			return nil, errTagValue
+		}
+		return int64(binary.BigEndian.Uint64(value)), nil
+	case protogen.Tag_BOOL:
+		switch len(value) {
+		case 1:
+			return value[0] != 0, nil
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(value)), nil
+		}
+		return nil, errTagValue
+	case protogen.Tag_FLOAT:
+		if len(value) != 8 {
+			return nil, errTagValue
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(value)), nil
+	}
+	return nil, errTagValue
+}
+
+// newSpanData 复制 span 中的数据,返回的 SpanData 不再引用 span,span 可以放回对象池.
+func newSpanData(sp *Span) *SpanData {
+	sd := &SpanData{
+		ServiceName:         sp.ServiceName(),
+		OperationName:       sp.operationName,
+		TraceId:             sp.context.TraceId,
+		SpanId:              sp.context.SpanId,
+		ParentId:            sp.context.ParentId,
+		SamplingProbability: sp.context.Probability,
+		Flags:               sp.context.Flags,
+		Env:                 sp.dapper.resource.Env,
+		StartTime:           sp.startTime,
+		Duration:            sp.duration,
+		Resource:            sp.dapper.resource,
+		level:               sp.context.Level,
+	}
+	if sp.context.ParentId != 0 {
+		sd.References = append(sd.References, SpanRef{Type: RefType(sp.refType), TraceId: sp.context.TraceId, SpanId: sp.context.ParentId})
+	}
+	sd.References = append(sd.References, sp.links...)
+	sd.Tags = make([]Tag, len(sp.tags))
+	for i, tag := range sp.tags {
+		sd.Tags[i] = Tag{Key: tag.Key, Value: normalizeValue(tag.Value)}
+	}
+	sd.Logs = make([]LogData, len(sp.logs))
+	for i, log := range sp.logs {
+		fields := make([]Tag, len(log.fields))
+		for j, field := range log.fields {
+			var value interface{} = field.Value
+			if field.value != nil {
+				value = normalizeValue(field.value)
+			}
+			fields[j] = Tag{Key: field.Key, Value: value}
+		}
+		sd.Logs[i] = LogData{Timestamp: time.Unix(0, log.timestamp), Fields: fields}
+	}
+	return sd
+}
@@ -0,0 +1,85 @@
+package trace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	protogen "github.com/aluka-7/trace/proto"
+)
+
+func TestUnmarshalSpan(t *testing.T) {
+	t1 := NewTracer("service1", nil, &mockReport{}, true, WithEnv("uat"))
+	sp1 := t1.New("opt_server").(*Span)
+	sp2 := sp1.Fork("", "opt_client").(*Span)
+	sp2.SetTag(TagString("str", "hello"), TagInt64("int", -3), TagBool("bool", true), TagFloat64("float", 3.14159))
+	sp2.SetLog(Log("event", "retry"), LogInt64("attempt", 3))
+	sp2.duration = 1500 * time.Millisecond
+
+	tags := []Tag{
+		TagString(TagSpanKind, "client"),
+		TagString("str", "hello"),
+		TagInt64("int", -3),
+		TagBool("bool", true),
+		TagFloat64("float", 3.14159),
+	}
+	t.Run("test v1", func(t *testing.T) {
+		data, err := marshalSpan(newSpanData(sp2), protoVersion1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := UnmarshalSpan(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "service1", sd.ServiceName)
+		assert.Equal(t, "opt_client", sd.OperationName)
+		assert.Equal(t, sp1.context.SpanId, sd.ParentId)
+		assert.True(t, sp2.startTime.Equal(sd.StartTime))
+		assert.Equal(t, 1500*time.Millisecond, sd.Duration)
+		assert.Equal(t, tags, sd.Tags)
+		assert.Equal(t, []SpanRef{{Type: RefChildOf, TraceId: sp1.context.TraceId, SpanId: sp1.context.SpanId}}, sd.References)
+		assert.Equal(t, []Tag{{Key: "event", Value: "retry"}, {Key: "attempt", Value: "3"}}, sd.Logs[0].Fields)
+		assert.Equal(t, sp2.logs[0].timestamp, sd.Logs[0].Timestamp.UnixNano())
+	})
+	t.Run("test v2", func(t *testing.T) {
+		data, err := marshalSpan(newSpanData(sp2), protoVersion2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := UnmarshalSpan(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "uat", sd.Env)
+		assert.Equal(t, tags, sd.Tags)
+		assert.Equal(t, []SpanRef{{Type: RefChildOf, TraceId: sp1.context.TraceId, SpanId: sp1.context.SpanId}}, sd.References)
+		assert.Equal(t, []Tag{{Key: "event", Value: "retry"}, {Key: "attempt", Value: int64(3)}}, sd.Logs[0].Fields)
+	})
+	t.Run("test invalid tag value", func(t *testing.T) {
+		_, err := parseTagValue(protogen.Tag_INT, []byte{1})
+		assert.Equal(t, errTagValue, err)
+		_, err = fromProtoSpan(&protogen.Span{Version: protoVersion1, Tags: []*protogen.Tag{{Key: "k", Kind: protogen.Tag_BOOL}}})
+		assert.ErrorIs(t, err, errTagValue)
+		_, err = fromProtoSpan(&protogen.Span{Version: 3})
+		assert.Equal(t, errSpanVersion, err)
+	})
+}
+
+func TestSpanDataSnapshot(t *testing.T) {
+	report := &mockReport{}
+	t1 := NewTracer("service1", nil, report, true)
+	sp1 := t1.New("opt_server")
+	sp1.SetTag(TagString("k", "v")).SetLog(LogInt64("attempt", 1))
+	sp1.Finish(nil)
+	// 对象池中的 span 被复用后,上报器持有的快照不受影响
+	for i := 0; i < 10; i++ {
+		t1.New("opt_other").SetTag(TagString("k", "other")).SetLog(Log("event", "other"))
+	}
+	sd := report.sps[0]
+	assert.Equal(t, "opt_server", sd.OperationName)
+	assert.Equal(t, []Tag{TagString(TagSpanKind, "server"), TagString("k", "v")}, sd.Tags)
+	assert.Equal(t, []Tag{{Key: "attempt", Value: int64(1)}}, sd.Logs[0].Fields)
+	assert.Equal(t, 1, sd.level)
+}
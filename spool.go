@@ -0,0 +1,261 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSpoolSize   = 1024 * 1024 * 256
+	spoolSegmentSize   = 1024 * 1024 * 8
+	spoolSegmentSuffix = ".seg"
+)
+
+// spool 上报目标不可用时将数据暂存在磁盘上,恢复后按写入顺序重放.
+//
+// 数据按顺序写入目录下的分段文件,每条记录为 uvarint(spans) + uvarint(length) + data,
+// spans 为 data 中 span 的数量,用于在丢弃分段时统计丢失的 span,不需要解码 data.
+// 分段中的记录全部重放成功后才删除该分段,进程重启后从最旧的分段继续重放,
+// 因此重放的语义为至少一次.总大小超过上限时丢弃最旧的分段,
+// 分段大小不超过上限的四分之一,因此较小的上限同样能按分段丢弃.
+// spool 不是并发安全的,只能由上报器的 daemon 协程使用.
+var _ sendQueue = &spool{}
+
+type spool struct {
+	dir     string
+	maxSize int64
+	total   int64
+	// segmentSize 分段写满后换新分段的大小
+	segmentSize int64
+
+	// segments 按从旧到新排列的分段序号
+	segments []uint64
+	sizes    map[uint64]int64
+	// spans 每个分段中尚未重放的 span 数量
+	spans map[uint64]int64
+	// w 正在写入的最新分段
+	w *os.File
+	// pending 已加载到内存、等待重放的最旧分段中的记录
+	pending []queueItem
+	loaded  bool
+}
+
+// openSpool 打开目录中已有的分段,不存在时创建目录.
+func openSpool(dir string, maxSize int64) (*spool, error) {
+	if maxSize <= 0 {
+		maxSize = defaultSpoolSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir, maxSize: maxSize, segmentSize: spoolSegmentSize, sizes: make(map[uint64]int64), spans: make(map[uint64]int64)}
+	if maxSize/4 < s.segmentSize {
+		s.segmentSize = maxSize / 4
+	}
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		// 只读取记录头统计 span 数量,每个分段只在打开时读取一次
+		spans, err := countSegment(file)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seq)
+		s.sizes[seq] = info.Size()
+		s.spans[seq] = spans
+		s.total += info.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+	return s, nil
+}
+
+func (s *spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentSuffix))
+}
+
+func (s *spool) empty() bool {
+	return len(s.segments) == 0
+}
+
+// push 追加一条包含 spans 个 span 的记录,返回因超过总大小上限而丢弃的 span 数量.
+func (s *spool) push(data []byte, spans int) (dropped int, err error) {
+	if s.w == nil || s.sizes[s.segments[len(s.segments)-1]] >= s.segmentSize {
+		if err = s.newSegment(); err != nil {
+			return
+		}
+	}
+	seq := s.segments[len(s.segments)-1]
+	record := make([]byte, 0, uvarintSize(spans)+uvarintSize(len(data))+len(data))
+	record = appendUvarint(record, uint64(spans))
+	record = appendUvarint(record, uint64(len(data)))
+	n, err := s.w.Write(append(record, data...))
+	s.sizes[seq] += int64(n)
+	s.total += int64(n)
+	if err != nil {
+		return
+	}
+	s.spans[seq] += int64(spans)
+	for s.total > s.maxSize && len(s.segments) > 1 {
+		dropped += int(s.spans[s.segments[0]])
+		if err = s.removeOldest(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *spool) newSegment() error {
+	if s.w != nil {
+		s.w.Close()
+	}
+	var seq uint64
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1] + 1
+	}
+	w, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.w = w
+	s.segments = append(s.segments, seq)
+	s.sizes[seq] = 0
+	return nil
+}
+
+// peek 返回最旧的一条记录以及其中的 span 数量,不会将其移除.
+func (s *spool) peek() ([]byte, int, error) {
+	for !s.loaded || len(s.pending) == 0 {
+		if s.loaded {
+			// 当前分段已经全部重放
+			if err := s.removeOldest(); err != nil {
+				return nil, 0, err
+			}
+		}
+		if s.empty() {
+			return nil, 0, nil
+		}
+		if err := s.load(); err != nil {
+			return nil, 0, err
+		}
+	}
+	return s.pending[0].data, s.pending[0].spans, nil
+}
+
+// pop 移除 peek 返回的记录.
+func (s *spool) pop() error {
+	s.spans[s.segments[0]] -= int64(s.pending[0].spans)
+	s.pending = s.pending[1:]
+	if len(s.pending) == 0 {
+		return s.removeOldest()
+	}
+	return nil
+}
+
+// load 将最旧的分段加载到内存,末尾不完整的记录(写入时进程退出)会被忽略.
+func (s *spool) load() error {
+	seq := s.segments[0]
+	if len(s.segments) == 1 && s.w != nil {
+		// 重放正在写入的分段前先将其封存,之后的数据写入新的分段
+		s.w.Close()
+		s.w = nil
+	}
+	records, err := s.readSegment(seq)
+	if err != nil {
+		return err
+	}
+	s.pending, s.loaded = records, true
+	return nil
+}
+
+// readSegment 读取分段中的全部记录,末尾不完整的记录会被忽略.
+func (s *spool) readSegment(seq uint64) ([]queueItem, error) {
+	data, err := os.ReadFile(s.path(seq))
+	if err != nil {
+		return nil, err
+	}
+	var records []queueItem
+	for len(data) > 0 {
+		spans, n := binary.Uvarint(data)
+		if n <= 0 {
+			break
+		}
+		length, m := binary.Uvarint(data[n:])
+		if m <= 0 || uint64(len(data)-n-m) < length {
+			break
+		}
+		data = data[n+m:]
+		records = append(records, queueItem{data: data[:length], spans: int(spans)})
+		data = data[length:]
+	}
+	return records, nil
+}
+
+// countSegment 返回分段文件中完整记录的 span 数量之和,跳过记录的数据部分.
+func countSegment(file string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var total int64
+	for {
+		spans, err := binary.ReadUvarint(r)
+		if err != nil {
+			break
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			break
+		}
+		if n, err := r.Discard(int(length)); err != nil || uint64(n) != length {
+			break
+		}
+		total += int64(spans)
+	}
+	return total, nil
+}
+
+func (s *spool) removeOldest() error {
+	seq := s.segments[0]
+	if len(s.segments) == 1 && s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+	s.segments = s.segments[1:]
+	s.total -= s.sizes[seq]
+	delete(s.sizes, seq)
+	delete(s.spans, seq)
+	s.pending, s.loaded = nil, false
+	if err := os.Remove(s.path(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *spool) size() int64 {
+	return s.total
+}
+
+func (s *spool) Close() error {
+	if s.w != nil {
+		return s.w.Close()
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aluka-7/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpool(t *testing.T) {
+	t.Run("test replay in order after restart", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := openSpool(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			s.push([]byte(fmt.Sprintf("data%d", i)), 1)
+		}
+		data, _, _ := s.peek()
+		assert.Equal(t, []byte("data0"), data)
+		s.pop()
+		// 重放过程中写入的数据在已加载的数据之后
+		s.push([]byte("data3"), 1)
+		s.Close()
+
+		s, err = openSpool(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		// 已加载但未删除的分段会被重新重放
+		var got []string
+		for !s.empty() {
+			data, _, err := s.peek()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data == nil {
+				break
+			}
+			got = append(got, string(data))
+			s.pop()
+		}
+		assert.Equal(t, []string{"data0", "data1", "data2", "data3"}, got)
+		files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentSuffix))
+		assert.Empty(t, files)
+	})
+	t.Run("test drop oldest segment", func(t *testing.T) {
+		s, err := openSpool(t.TempDir(), spoolSegmentSize+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		data := make([]byte, 1024*1024)
+		dropped := 0
+		for i := 0; i < 10; i++ {
+			data[0] = byte(i)
+			n, err := s.push(data, 2)
+			assert.Nil(t, err)
+			dropped += n
+		}
+		assert.True(t, s.size() <= spoolSegmentSize+1)
+		// 丢弃的记录均在剩余记录之前
+		assert.Equal(t, seqRange(dropped/2, 10), drainSpool(t, s))
+	})
+	t.Run("test drop below segment size", func(t *testing.T) {
+		s, err := openSpool(t.TempDir(), 1024*1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		data := make([]byte, 64*1024)
+		dropped := 0
+		for i := 0; i < 100; i++ {
+			data[0] = byte(i)
+			n, err := s.push(data, 1)
+			assert.Nil(t, err)
+			dropped += n
+		}
+		assert.True(t, s.size() <= 1024*1024)
+		assert.True(t, dropped > 0)
+		assert.Equal(t, seqRange(dropped, 100), drainSpool(t, s))
+	})
+	t.Run("test ignore truncated record", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := openSpool(dir, 0)
+		s.push([]byte("hello"), 3)
+		s.Close()
+		file := s.path(0)
+		data, _ := os.ReadFile(file)
+		os.WriteFile(file, append(data, 10, 'x'), 0644)
+		s, _ = openSpool(dir, 0)
+		defer s.Close()
+		data, spans, _ := s.peek()
+		assert.Equal(t, []byte("hello"), data)
+		assert.Equal(t, 3, spans)
+		assert.Len(t, s.pending, 1)
+		assert.Equal(t, int64(3), s.spans[0])
+	})
+}
+
+// drainSpool 重放 spool 中的全部记录,返回每条记录的首字节.
+func drainSpool(t *testing.T, s *spool) []byte {
+	var got []byte
+	for !s.empty() {
+		data, _, err := s.peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data == nil {
+			break
+		}
+		got = append(got, data[0])
+		s.pop()
+	}
+	return got
+}
+
+func seqRange(from, to int) []byte {
+	var seq []byte
+	for i := from; i < to; i++ {
+		seq = append(seq, byte(i))
+	}
+	return seq
+}
+
+func TestReportSpool(t *testing.T) {
+	dir := t.TempDir()
+	report := newConnReport(&Config{
+		Network:         "tcp",
+		Addr:            "127.0.0.1:6080",
+		ProtocolVersion: protoVersion2,
+		FlushInterval:   utils.Duration(10 * time.Millisecond),
+		SpoolDir:        dir,
+	})
+	report.backoff = backoff{min: 10 * time.Millisecond, max: 10 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		report.writePackage([]byte(fmt.Sprintf("data%d", i)))
+	}
+	// 目标不可用时数据写入 spool
+	time.Sleep(50 * time.Millisecond)
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentSuffix))
+	assert.NotEmpty(t, files)
+
+	buf := &bytes.Buffer{}
+	cancel, err := newServer(buf, "tcp", "127.0.0.1:6080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 恢复后由定时器触发重放,之后的数据排在重放的数据之后
+	time.Sleep(100 * time.Millisecond)
+	report.writePackage([]byte("data3"))
+	if err := report.Close(); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	for i := 0; i < 4; i++ {
+		_, _, payload, err := readFrame(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, fmt.Sprintf("data%d", i), string(payload))
+	}
+	files, _ = filepath.Glob(filepath.Join(dir, "*"+spoolSegmentSuffix))
+	assert.Empty(t, files)
+}
@@ -0,0 +1,102 @@
+package trace
+
+import (
+	"expvar"
+	"sync/atomic"
+)
+
+// ReportStats 上报器的统计数据,用于发现上报过程中 span 的丢失.
+type ReportStats struct {
+	// Queued 成功放入发送队列的 span 数量
+	Queued int64 `json:"queued"`
+	// Sent 成功发送的 span 数量
+	Sent int64 `json:"sent"`
+	// Failed 发送失败后被丢弃的 span 数量
+	Failed int64 `json:"failed"`
+	// DroppedTimeout 发送队列已满,等待超时后丢弃的 span 数量
+	DroppedTimeout int64 `json:"dropped_timeout"`
+	// DroppedTooLarge 截断后仍然超过大小上限而被丢弃的 span 数量
+	DroppedTooLarge int64 `json:"dropped_too_large"`
+	// Truncated 超过大小上限而被截断后发送的 span 数量
+	Truncated int64 `json:"truncated"`
+	// DroppedClosed 上报器关闭后写入而被丢弃的 span 数量
+	DroppedClosed int64 `json:"dropped_closed"`
+	// MarshalErrors 编码失败的 span 数量
+	MarshalErrors int64 `json:"marshal_errors"`
+	// Reconnects 建立连接的次数,大于 1 说明连接曾经断开
+	Reconnects int64 `json:"reconnects"`
+	// QueueDepth 发送队列中等待发送的 span 数量
+	QueueDepth int64 `json:"queue_depth"`
+	// BufferedBytes 连接不可用时暂存、等待重发的字节数
+	BufferedBytes int64 `json:"buffered_bytes"`
+}
+
+func (s ReportStats) add(o ReportStats) ReportStats {
+	s.Queued += o.Queued
+	s.Sent += o.Sent
+	s.Failed += o.Failed
+	s.DroppedTimeout += o.DroppedTimeout
+	s.DroppedTooLarge += o.DroppedTooLarge
+	s.Truncated += o.Truncated
+	s.DroppedClosed += o.DroppedClosed
+	s.MarshalErrors += o.MarshalErrors
+	s.Reconnects += o.Reconnects
+	s.QueueDepth += o.QueueDepth
+	s.BufferedBytes += o.BufferedBytes
+	return s
+}
+
+// reportStats 上报器内部的计数器,可以在多个协程中并发更新.
+type reportStats struct {
+	queued, sent, failed                           int64
+	droppedTimeout, droppedTooLarge, droppedClosed int64
+	truncated, marshalErrors, reconnects, buffered int64
+}
+
+func (s *reportStats) snapshot() ReportStats {
+	return ReportStats{
+		Queued:          atomic.LoadInt64(&s.queued),
+		Sent:            atomic.LoadInt64(&s.sent),
+		Failed:          atomic.LoadInt64(&s.failed),
+		DroppedTimeout:  atomic.LoadInt64(&s.droppedTimeout),
+		DroppedTooLarge: atomic.LoadInt64(&s.droppedTooLarge),
+		Truncated:       atomic.LoadInt64(&s.truncated),
+		DroppedClosed:   atomic.LoadInt64(&s.droppedClosed),
+		MarshalErrors:   atomic.LoadInt64(&s.marshalErrors),
+		Reconnects:      atomic.LoadInt64(&s.reconnects),
+		BufferedBytes:   atomic.LoadInt64(&s.buffered),
+	}
+}
+
+// result 按发送结果累加成功或失败的 span 数量.
+func (s *reportStats) result(n int, err error) {
+	if err != nil {
+		atomic.AddInt64(&s.failed, int64(n))
+	} else {
+		atomic.AddInt64(&s.sent, int64(n))
+	}
+}
+
+// Stats 返回全局tracer上报器的统计数据,上报器不支持时ok为false.
+func Stats() (stats ReportStats, ok bool) {
+	d, ok := _tracer.(*dapper)
+	if !ok {
+		return
+	}
+	r, ok := d.reporter.(interface{ Stats() ReportStats })
+	if !ok {
+		return
+	}
+	return r.Stats(), true
+}
+
+// PublishStats 将全局tracer上报器的统计数据以 name 发布到 expvar,重复发布时忽略.
+func PublishStats(name string) {
+	if expvar.Get(name) != nil {
+		return
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		stats, _ := Stats()
+		return stats
+	}))
+}
@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportStats(t *testing.T) {
+	t.Run("test conn report", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		cancel, err := newServer(buf, "tcp", "127.0.0.1:6082")
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6082", BatchSize: 2})
+		report.writePackage([]byte("hello"))
+		report.writePackage([]byte("world"))
+		report.writePackage(make([]byte, maxPackageSize+1))
+		if err := report.Close(); err != nil {
+			t.Error(err)
+		}
+		cancel()
+		report.writePackage([]byte("closed"))
+		stats := report.Stats()
+		assert.Equal(t, int64(2), stats.Queued)
+		assert.Equal(t, int64(2), stats.Sent)
+		assert.Equal(t, int64(1), stats.DroppedTooLarge)
+		assert.Equal(t, int64(1), stats.DroppedClosed)
+		assert.Equal(t, int64(1), stats.Reconnects)
+		assert.Equal(t, int64(0), stats.QueueDepth)
+	})
+	t.Run("test failed spans", func(t *testing.T) {
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6083"})
+		report.writePackage([]byte("hello"))
+		report.Close()
+		stats := report.Stats()
+		assert.Equal(t, int64(0), stats.Sent)
+		assert.Equal(t, int64(1), stats.Failed)
+	})
+	t.Run("test multi report", func(t *testing.T) {
+		r1 := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6083", ProtocolVersion: protoVersion1})
+		r2 := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6083", ProtocolVersion: protoVersion1})
+		report := newMultiReport(r1, r2, newConsoleReport(&bytes.Buffer{}))
+		t1 := NewTracer("service1", nil, report, true)
+		t1.New("opt").Finish(nil)
+		report.Close()
+		stats := report.Stats()
+		assert.Equal(t, int64(2), stats.Queued)
+		assert.Equal(t, int64(2), stats.Failed)
+	})
+	t.Run("test global stats and expvar", func(t *testing.T) {
+		old := _tracer
+		defer func() { _tracer = old }()
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6083"})
+		defer report.Close()
+		_tracer = NewTracer("service1", nil, report, true)
+		report.writePackage(make([]byte, maxPackageSize+1))
+		stats, ok := Stats()
+		assert.True(t, ok)
+		assert.Equal(t, int64(1), stats.DroppedTooLarge)
+
+		PublishStats("trace_test")
+		PublishStats("trace_test")
+		var published ReportStats
+		if err := json.Unmarshal([]byte(expvar.Get("trace_test").String()), &published); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, int64(1), published.DroppedTooLarge)
+	})
+}
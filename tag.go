@@ -1,5 +1,7 @@
 package trace
 
+import "strconv"
+
 // Standard Span tags https://github.com/opentracing/specification/blob/master/semantic_conventions.md#span-tags-table
 const (
 	// TagComponent The software package, framework, library, or module that generated the associated Span.
@@ -84,6 +86,10 @@ const (
 	// type string
 	TagSpanKind = "span.kind"
 
+	// TagTruncated true if the span exceeded the reporter's size limit and its tags or logs were truncated.
+	// type bool
+	TagTruncated = "trace.truncated"
+
 	// TagAnnotation legacy tag
 	TagAnnotation = "legacy.annotation"
 	TagAddress    = "legacy.address"
@@ -173,8 +179,25 @@ func Log(key string, val string) LogField {
 	return LogField{Key: key, Value: val}
 }
 
+// LogInt64 new int64 log, protocol v2 keeps the value type.
+func LogInt64(key string, val int64) LogField {
+	return LogField{Key: key, Value: strconv.FormatInt(val, 10), value: val}
+}
+
+// LogBool new bool log, protocol v2 keeps the value type.
+func LogBool(key string, val bool) LogField {
+	return LogField{Key: key, Value: strconv.FormatBool(val), value: val}
+}
+
+// LogFloat64 new float64 log, protocol v2 keeps the value type.
+func LogFloat64(key string, val float64) LogField {
+	return LogField{Key: key, Value: strconv.FormatFloat(val, 'g', -1, 64), value: val}
+}
+
 // LogField LogField
 type LogField struct {
 	Key   string
 	Value string
+	// value 非字符串类型的原始值
+	value interface{}
 }
@@ -0,0 +1,110 @@
+package trace
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// thrift compact 协议中的类型,见 https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+const (
+	compactStop       byte = 0x00
+	compactBoolTrue   byte = 0x01
+	compactBoolFalse  byte = 0x02
+	compactI32        byte = 0x05
+	compactI64        byte = 0x06
+	compactDouble     byte = 0x07
+	compactBinary     byte = 0x08
+	compactList       byte = 0x09
+	compactStruct     byte = 0x0C
+	compactProtocolID byte = 0x82
+	compactVersion    byte = 0x01
+
+	thriftOneway byte = 4
+)
+
+// compactWriter thrift compact 协议编码器,仅实现上报 jaeger 所需的部分.
+type compactWriter struct {
+	buf []byte
+	// 当前结构体上一个字段的 id 以及外层结构体的 id 栈
+	lastID  int16
+	idStack []int16
+}
+
+func (w *compactWriter) messageBegin(name string, typeID byte, seqID int32) {
+	w.buf = append(w.buf, compactProtocolID, compactVersion|typeID<<5)
+	w.varint(uint64(uint32(seqID)))
+	w.binary([]byte(name))
+}
+
+func (w *compactWriter) structBegin() {
+	w.idStack = append(w.idStack, w.lastID)
+	w.lastID = 0
+}
+
+func (w *compactWriter) structEnd() {
+	w.buf = append(w.buf, compactStop)
+	w.lastID = w.idStack[len(w.idStack)-1]
+	w.idStack = w.idStack[:len(w.idStack)-1]
+}
+
+func (w *compactWriter) fieldBegin(typeID byte, id int16) {
+	if delta := id - w.lastID; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typeID)
+	} else {
+		w.buf = append(w.buf, typeID)
+		w.varint(zigzag(int64(id)))
+	}
+	w.lastID = id
+}
+
+func (w *compactWriter) listBegin(elemType byte, size int) {
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elemType)
+		return
+	}
+	w.buf = append(w.buf, 0xF0|elemType)
+	w.varint(uint64(size))
+}
+
+func (w *compactWriter) boolField(id int16, v bool) {
+	if v {
+		w.fieldBegin(compactBoolTrue, id)
+	} else {
+		w.fieldBegin(compactBoolFalse, id)
+	}
+}
+
+func (w *compactWriter) i32Field(id int16, v int32) {
+	w.fieldBegin(compactI32, id)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *compactWriter) i64Field(id int16, v int64) {
+	w.fieldBegin(compactI64, id)
+	w.varint(zigzag(v))
+}
+
+func (w *compactWriter) doubleField(id int16, v float64) {
+	w.fieldBegin(compactDouble, id)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *compactWriter) stringField(id int16, v string) {
+	w.fieldBegin(compactBinary, id)
+	w.binary([]byte(v))
+}
+
+func (w *compactWriter) binary(v []byte) {
+	w.varint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *compactWriter) varint(v uint64) {
+	w.buf = appendUvarint(w.buf, v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	errs "errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+var errTLSServerName = errs.New("trace: tls server_name is required for unix network")
+
+// TLSConfig 上报连接的TLS配置,设置了客户端证书时启用双向TLS.
+type TLSConfig struct {
+	// CAFile 校验服务端证书的CA证书文件(PEM),默认使用系统根证书
+	CAFile string `json:"ca_file"`
+	// CertFile 客户端证书文件(PEM),与KeyFile同时设置
+	CertFile string `json:"cert_file"`
+	// KeyFile 客户端私钥文件(PEM)
+	KeyFile string `json:"key_file"`
+	// ServerName 校验服务端证书的主机名,默认为地址中的主机名,unix 网络下必须设置
+	ServerName string `json:"server_name"`
+	// InsecureSkipVerify 不校验服务端证书,仅用于测试
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+// newTLSConfig 根据配置加载证书,network 与 addr 为连接的网络与地址,用于确定默认的 ServerName.
+// unix 网络的地址为文件路径,不能作为 ServerName,需要在配置中指定.
+func newTLSConfig(cfg *TLSConfig, network, addr string) (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if conf.ServerName == "" {
+		if network == "unix" {
+			if !cfg.InsecureSkipVerify {
+				return nil, errTLSServerName
+			}
+		} else if host, _, err := net.SplitHostPort(addr); err == nil {
+			conf.ServerName = host
+		} else {
+			conf.ServerName = addr
+		}
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("trace: no certificate found in %s", cfg.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
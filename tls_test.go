@@ -0,0 +1,139 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert 生成由 ca 签发的证书,ca 为空时生成自签名的 CA 证书.
+func testCert(t *testing.T, ca *tls.Certificate, cn string, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := tmpl, interface{}(key)
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert 将证书与私钥以 PEM 格式写入 dir,返回文件路径.
+func writeCert(t *testing.T, dir, name string, cert tls.Certificate) (certFile, keyFile string) {
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	return
+}
+
+func TestReportTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := testCert(t, nil, "trace-ca")
+	caFile, _ := writeCert(t, dir, "ca", ca)
+	clientCert, clientKey := writeCert(t, dir, "client", testCert(t, &ca, "client"))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	lis, err := tls.Listen("tcp", "127.0.0.1:6085", &tls.Config{
+		Certificates: []tls.Certificate{testCert(t, &ca, "collector", "collector.local")},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := &bytes.Buffer{}
+				io.Copy(buf, conn)
+				if buf.Len() > 0 {
+					received <- buf.Bytes()
+				}
+			}(conn)
+		}
+	}()
+
+	t.Run("test mutual tls", func(t *testing.T) {
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6085", TLS: &TLSConfig{
+			CAFile:     caFile,
+			CertFile:   clientCert,
+			KeyFile:    clientKey,
+			ServerName: "collector.local",
+		}})
+		report.writePackage([]byte("hello"))
+		if err := report.Close(); err != nil {
+			t.Error(err)
+		}
+		select {
+		case data := <-received:
+			assert.Equal(t, []byte("hello"), data)
+		case <-time.After(time.Second):
+			t.Fatal("data not received")
+		}
+	})
+	t.Run("test server name mismatch", func(t *testing.T) {
+		conf, err := newTLSConfig(&TLSConfig{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey}, "tcp", "127.0.0.1:6085")
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1", conf.ServerName)
+		_, err = tls.Dial("tcp", "127.0.0.1:6085", conf)
+		assert.NotNil(t, err)
+	})
+	t.Run("test unix requires server name", func(t *testing.T) {
+		_, err := newTLSConfig(&TLSConfig{CAFile: caFile}, "unix", "/tmp/trace.sock")
+		assert.Equal(t, errTLSServerName, err)
+		conf, err := newTLSConfig(&TLSConfig{CAFile: caFile, ServerName: "collector.local"}, "unix", "/tmp/trace.sock")
+		assert.Nil(t, err)
+		assert.Equal(t, "collector.local", conf.ServerName)
+	})
+	t.Run("test invalid config never falls back to plaintext", func(t *testing.T) {
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6085", TLS: &TLSConfig{CAFile: filepath.Join(dir, "missing.crt")}})
+		defer report.Close()
+		assert.NotNil(t, report.reconnect(context.Background()))
+		report = newConnReport(&Config{Network: "udp", Addr: "127.0.0.1:6085", TLS: &TLSConfig{}})
+		defer report.Close()
+		assert.NotNil(t, report.reconnect(context.Background()))
+	})
+}
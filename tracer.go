@@ -1,6 +1,7 @@
 package trace
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -8,7 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"github.com/aluka-7/utils"
 )
@@ -42,19 +43,58 @@ const (
 
 // Config config.
 type Config struct {
-	// 报告网络,例如:Unix,TCP,UDP
+	// 报告网络,例如:Unix,TCP,UDP;
+	// 或者上报的目标系统,例如:zipkin,jaeger,otlp,otlpgrpc;
+	// 或者写入本地文件:file;
+	// 或者本地开发时输出到控制台:console
 	Network string `json:"network"`
 	// 对于TCP和UDP网络，地址的格式为“ host：port”。
 	// 对于Unix网络，该地址必须是文件系统路径。
+	// 对于zipkin，地址为HTTP地址，例如“http://127.0.0.1:9411”。
+	// 对于jaeger，地址为jaeger-agent的compact协议端口，默认为“127.0.0.1:6831”。
+	// 对于otlp，地址为OTLP/HTTP地址，例如“http://127.0.0.1:4318”。
+	// 对于otlpgrpc，地址为OTLP/gRPC地址，例如“127.0.0.1:4317”。
+	// 对于file，地址为文件路径。
+	// 对于console，地址为stdout(默认)或者stderr。
 	Addr string `json:"address"`
-	// 报告超时
+	// 报告超时,Unix,TCP,UDP网络下同时作为连接和每次写入的超时时间
 	Timeout utils.Duration `json:"timeout"`
 	// DisableSample
 	DisableSample bool `json:"disable_sample"`
-	// ProtocolVersion
+	// ProtocolVersion 上报协议版本,目前支持1和2.
+	// 两个版本都写入references,2 在1的基础上写入浮点数tag以及带类型的log字段,见marshal.go;
+	// Unix,TCP网络下每个span都带有记录该版本的帧头,见frame.go,1 保持原有的格式,直接写入span的编码.
+	// 批量上报时无论版本均使用帧格式,帧格式中NewTracer传入的tag不再写入每个span,而是写入Resource帧,见Resource
 	ProtocolVersion int32 `json:"protocol_version"`
+	// Env 部署环境,例如:dev,uat,prod,写入Resource以及protobuf协议中span的env字段,NewTracer 使用 WithEnv 设置
+	Env string `json:"env"`
 	// Probability probability sampling
 	Probability float32
+	// BatchSize 每次网络写入最多打包的span数量,小于等于1时不开启批量上报
+	BatchSize int `json:"batch_size"`
+	// Compression Unix,TCP,UDP网络下批量上报时的压缩算法,可选gzip,deflate,默认不压缩.
+	// 压缩后的批量包为一个设置了压缩标记的帧,接收方使用SpanReader或UnmarshalBatch读取
+	Compression string `json:"compression"`
+	// FlushInterval 批量上报的最长等待时间,默认1秒
+	FlushInterval utils.Duration `json:"flush_interval"`
+	// Format 写入文件的格式,json(默认,每行一个zipkin v2 span),span_json(每行一个span,见json.go)或者protobuf(帧格式,见frame.go)
+	Format string `json:"format"`
+	// MaxFileSize 单个文件的大小上限,超过后轮转,默认100MB
+	MaxFileSize int64 `json:"max_file_size"`
+	// RotateInterval 文件按时间轮转的间隔,默认不按时间轮转
+	RotateInterval utils.Duration `json:"rotate_interval"`
+	// MaxBackups 保留的轮转文件数量,默认全部保留
+	MaxBackups int `json:"max_backups"`
+	// SpoolDir Unix,TCP,UDP网络下上报失败时暂存数据的目录,连接恢复后按顺序重放,默认暂存在内存中
+	SpoolDir string `json:"spool_dir"`
+	// SpoolSize 暂存数据的大小上限,超过后丢弃最旧的数据,默认256MB
+	SpoolSize int64 `json:"spool_size"`
+	// TLS Unix,TCP网络以及otlpgrpc使用TLS连接,默认不加密
+	TLS *TLSConfig `json:"tls"`
+	// StrictSpan span 结束后仍被修改或者重复 Finish 时输出调用方的堆栈,用于排查问题,默认只计数,见 LateSpanCalls,NewTracer 使用 WithStrictSpan 设置
+	StrictSpan bool `json:"strict_span"`
+	// Reporters 同时上报到多个目标,例如迁移期间双写,设置后忽略Network
+	Reporters []*Config `json:"reporters"`
 }
 
 // Trace trace common interface.
@@ -63,8 +103,11 @@ type Trace interface {
 	TraceId() string
 
 	// Fork 用客户端跟踪派生一个跟踪。
+	// serviceName 为被调用的服务,不为空时写入 peer.service 标签,
+	// 例如 redis 或者第三方 HTTP 接口等没有接入跟踪的依赖,使其在依赖关系图中显示为单独的节点.
 	Fork(serviceName, operationName string) Trace
 
+	// Follow 派生一个以 FOLLOWS_FROM 引用当前跟踪的跟踪,例如异步消息的生产者.
 	Follow(serviceName, operationName string) Trace
 
 	// Finish 当跟踪完成时调用它.
@@ -93,6 +136,24 @@ type Trace interface {
 	SetTitle(title string)
 }
 
+// Linker 支持记录 FOLLOWS_FROM 引用的 Trace,见 Link.
+// 没有加入 Trace 接口,外部的 Trace 实现不需要修改.
+type Linker interface {
+	Link(contexts ...string) error
+}
+
+// Link 为 t 记录与 contexts 对应的 span 的 FOLLOWS_FROM 引用,例如批量消费时引用每条消息的生产者 span.
+// contexts 为被引用 span 的 TraceId(),即 Inject 写入的 SystemTraceID 的值,
+// 消费者应当直接使用消息中的该值,而不是 Extract 返回的 Trace,后者是新的子 span.
+// 被引用的 span 可以属于其他 trace 或者已经结束.t 不支持时返回 ErrInvalidTrace.
+func Link(t Trace, contexts ...string) error {
+	l, ok := t.(Linker)
+	if !ok {
+		return ErrInvalidTrace
+	}
+	return l.Link(contexts...)
+}
+
 // Tracer 是用于跟踪创建和传播的简单,轻界面.
 type Tracer interface {
 	// New trace instance with given title.
@@ -108,8 +169,13 @@ type Tracer interface {
 // Init init trace report.
 func Init(serviceName string, tags []Tag, cfg *Config) {
 	fmt.Println("Loading Trace Engine")
-	report := newReport(cfg.Network, cfg.Addr, time.Duration(cfg.Timeout), cfg.ProtocolVersion)
-	SetGlobalTracer(NewTracer(serviceName, tags, report, cfg.DisableSample))
+	report := newReporter(cfg)
+	opts := []TracerOption{WithEnv(cfg.Env)}
+	if cfg.StrictSpan {
+		opts = append(opts, WithStrictSpan())
+	}
+	tracer := NewTracer(serviceName, tags, report, cfg.DisableSample, opts...)
+	SetGlobalTracer(tracer)
 }
 
 // SetGlobalTracer SetGlobalTracer
@@ -119,7 +185,11 @@ func SetGlobalTracer(tracer Tracer) {
 }
 
 // NewTracer new a tracer.
-func NewTracer(serviceName string, tags []Tag, report reporter, disableSample bool) Tracer {
+func NewTracer(serviceName string, tags []Tag, report reporter, disableSample bool, opts ...TracerOption) Tracer {
+	opt := tracerOption{}
+	for _, fn := range opts {
+		fn(&opt)
+	}
 	sampler := newSampler(probability)
 	stdLog := log.New(os.Stderr, "trace", log.LstdFlags)
 	return &dapper{
@@ -128,8 +198,9 @@ func NewTracer(serviceName string, tags []Tag, report reporter, disableSample bo
 		propagators:   map[interface{}]propagator{HTTPFormat: httpPropagator{}, GRPCFormat: gRpcPropagator{}},
 		reporter:      report,
 		sampler:       sampler,
-		tags:          tags,
-		pool:          &sync.Pool{New: func() interface{} { return new(Span) }},
+		resource:      newResource(serviceName, opt.Env, tags),
+		strict:        opt.StrictSpan,
+		pool:          &sync.Pool{New: func() interface{} { return new(spanState) }},
 		stdLog:        stdLog,
 	}
 }
@@ -137,7 +208,8 @@ func NewTracer(serviceName string, tags []Tag, report reporter, disableSample bo
 var defaultOption = option{}
 
 type option struct {
-	Debug bool
+	Debug       bool
+	ServiceName string
 }
 
 // Option dapper Option
@@ -150,6 +222,36 @@ func EnableDebug() Option {
 	}
 }
 
+// WithServiceName 使用 serviceName 代替 tracer 的服务名上报 span 及其子 span,
+// 例如一个进程内运行多个逻辑服务.
+func WithServiceName(serviceName string) Option {
+	return func(opt *option) {
+		opt.ServiceName = serviceName
+	}
+}
+
+type tracerOption struct {
+	Env        string
+	StrictSpan bool
+}
+
+// TracerOption NewTracer 的可选配置
+type TracerOption func(*tracerOption)
+
+// WithEnv 设置部署环境,见 Config.Env
+func WithEnv(env string) TracerOption {
+	return func(opt *tracerOption) {
+		opt.Env = env
+	}
+}
+
+// WithStrictSpan span 结束后仍被修改或者重复 Finish 时输出调用方的堆栈,见 Config.StrictSpan
+func WithStrictSpan() TracerOption {
+	return func(opt *tracerOption) {
+		opt.StrictSpan = true
+	}
+}
+
 // New trace instance with given operationName.
 func New(operationName string, opts ...Option) Trace {
 	return _tracer.New(operationName, opts...)
@@ -173,6 +275,30 @@ func Close() error {
 	return nil
 }
 
+// LateSpanCalls 返回全局tracer中 span 结束后仍被修改或者重复 Finish 的次数,这些调用均被忽略.
+func LateSpanCalls() int64 {
+	if d, ok := _tracer.(*dapper); ok {
+		return atomic.LoadInt64(&d.lateCalls)
+	}
+	return 0
+}
+
+// Flush 等待全局tracer已结束的span发送完毕,或者直到ctx结束,例如在短时任务退出前调用.
+func Flush(ctx context.Context) error {
+	if f, ok := _tracer.(interface{ Flush(context.Context) error }); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+// Shutdown 关闭全局tracer的上报器,等待已结束的span发送完毕,或者直到ctx结束,例如在preStop中调用.
+func Shutdown(ctx context.Context) error {
+	if s, ok := _tracer.(interface{ Shutdown(context.Context) error }); ok {
+		return s.Shutdown(ctx)
+	}
+	return Close()
+}
+
 // SpanContext实现opentracing.SpanContext
 type spanContext struct {
 	// TraceId 表示跟踪的全局唯一Id;通常生成为随机数.
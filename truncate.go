@@ -0,0 +1,87 @@
+package trace
+
+import (
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// 截断时字符串类型 tag 值保留的最大字节数
+	truncateValueSize = 256
+	truncateSuffix    = "..."
+)
+
+// truncateSpan 编码 span,超过 maxSize 时依次截断过长的 tag 值、丢弃 logs、丢弃 tags,直到编码结果不超过 maxSize.
+// 截断的 span 带有 TagTruncated 标签,保留时间信息以及 error 与 span.kind 标签.
+// 截断只作用于 SpanData 的副本;全部截断后仍然超过 maxSize 时返回最后一次的编码结果,由调用方丢弃.
+func truncateSpan(sd *SpanData, maxSize int, marshal func(sd *SpanData) ([]byte, error)) (data []byte, truncated bool, err error) {
+	if data, err = marshal(sd); err != nil || len(data) <= maxSize {
+		return
+	}
+	t := *sd
+	t.Tags = append([]Tag{TagBool(TagTruncated, true)}, t.Tags...)
+	for i, tag := range t.Tags {
+		if v, ok := tag.Value.(string); ok && len(v) > truncateValueSize {
+			t.Tags[i].Value = truncateString(v, truncateValueSize) + truncateSuffix
+		}
+	}
+	if data, err = marshal(&t); err != nil || len(data) <= maxSize {
+		return data, err == nil, err
+	}
+	// 从最后的 log 开始丢弃,按估算的大小一次丢弃足够多的 log,减少重新编码的次数
+	for len(t.Logs) > 0 && len(data) > maxSize {
+		excess := len(data) - maxSize
+		for excess > 0 && len(t.Logs) > 0 {
+			excess -= logSize(t.Logs[len(t.Logs)-1])
+			t.Logs = t.Logs[:len(t.Logs)-1]
+		}
+		if data, err = marshal(&t); err != nil {
+			return
+		}
+	}
+	for len(data) > maxSize {
+		excess := len(data) - maxSize
+		for i := len(t.Tags) - 1; i >= 0 && excess > 0; i-- {
+			if keepTag(t.Tags[i].Key) {
+				continue
+			}
+			excess -= tagSize(t.Tags[i])
+			t.Tags = append(t.Tags[:i], t.Tags[i+1:]...)
+		}
+		if excess == len(data)-maxSize {
+			// 没有可以丢弃的 tag
+			break
+		}
+		if data, err = marshal(&t); err != nil {
+			return
+		}
+	}
+	return data, len(data) <= maxSize, nil
+}
+
+// truncateString 截断 s 使其不超过 n 个字节,不会拆分多字节的 UTF-8 字符.
+func truncateString(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// keepTag 截断时保留的 tag.
+func keepTag(key string) bool {
+	return key == TagTruncated || key == TagError || key == TagSpanKind
+}
+
+// tagSize 估算 tag 编码后的大小,包含字段头的开销.
+func tagSize(tag Tag) int {
+	return proto.Size(toProtoTag(tag)) + 4
+}
+
+func logSize(log LogData) int {
+	size := 16
+	for _, field := range log.Fields {
+		size += len(field.Key) + len(logValueString(field.Value)) + 8
+	}
+	return size
+}
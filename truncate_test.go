@@ -0,0 +1,99 @@
+package trace
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	protogen "github.com/aluka-7/trace/proto"
+)
+
+func TestTruncateSpan(t *testing.T) {
+	report := &mockReport{}
+	t1 := NewTracer("service1", nil, report, true)
+	marshal := func(sd *SpanData) ([]byte, error) { return marshalSpan(sd, protoVersion1) }
+	unmarshal := func(data []byte) *protogen.Span {
+		sp := new(protogen.Span)
+		if err := proto.Unmarshal(data, sp); err != nil {
+			t.Fatal(err)
+		}
+		return sp
+	}
+	tagValue := func(sp *protogen.Span, key string) (string, bool) {
+		for _, tag := range sp.Tags {
+			if tag.Key == key {
+				return string(tag.Value), true
+			}
+		}
+		return "", false
+	}
+	t.Run("test small span untouched", func(t *testing.T) {
+		sd := newSpanData(t1.New("opt").(*Span))
+		data, truncated, err := truncateSpan(sd, maxPackageSize, marshal)
+		assert.Nil(t, err)
+		assert.False(t, truncated)
+		_, ok := tagValue(unmarshal(data), TagTruncated)
+		assert.False(t, ok)
+	})
+	t.Run("test trim long tag value", func(t *testing.T) {
+		sd := newSpanData(t1.New("opt").SetTag(TagString(TagDBStatement, strings.Repeat("x", maxPackageSize))).(*Span))
+		data, truncated, err := truncateSpan(sd, maxPackageSize, marshal)
+		assert.Nil(t, err)
+		assert.True(t, truncated)
+		assert.True(t, len(data) <= maxPackageSize)
+		ps := unmarshal(data)
+		_, ok := tagValue(ps, TagTruncated)
+		assert.True(t, ok)
+		statement, _ := tagValue(ps, TagDBStatement)
+		assert.Equal(t, strings.Repeat("x", truncateValueSize)+truncateSuffix, statement)
+		// 原 SpanData 不受影响
+		assert.Len(t, sd.Tags[len(sd.Tags)-1].Value, maxPackageSize)
+	})
+	t.Run("test trim at character boundary", func(t *testing.T) {
+		// 每个字符 3 个字节,truncateValueSize 处为字符的中间
+		sd := newSpanData(t1.New("opt").SetTag(TagString(TagDBStatement, strings.Repeat("中", maxPackageSize))).(*Span))
+		data, truncated, err := truncateSpan(sd, maxPackageSize, marshal)
+		assert.Nil(t, err)
+		assert.True(t, truncated)
+		statement, _ := tagValue(unmarshal(data), TagDBStatement)
+		assert.True(t, utf8.ValidString(statement))
+		assert.Equal(t, strings.Repeat("中", truncateValueSize/3)+truncateSuffix, statement)
+	})
+	t.Run("test drop logs then tags", func(t *testing.T) {
+		sp := t1.New("opt").(*Span)
+		for i := 0; i < 100; i++ {
+			sp.SetLog(Log(LogMessage, strings.Repeat("y", 200)))
+		}
+		sd := newSpanData(sp)
+		data, truncated, err := truncateSpan(sd, 4096, marshal)
+		assert.Nil(t, err)
+		assert.True(t, truncated)
+		assert.True(t, len(data) <= 4096)
+		ps := unmarshal(data)
+		assert.True(t, len(ps.Logs) > 0 && len(ps.Logs) < 100)
+		assert.Len(t, sd.Logs, 100)
+
+		for i := 0; i < 100; i++ {
+			sp.SetTag(TagString("key"+strings.Repeat("k", 10), strings.Repeat("v", 200)))
+		}
+		data, truncated, err = truncateSpan(newSpanData(sp), 1024, marshal)
+		assert.Nil(t, err)
+		assert.True(t, truncated)
+		ps = unmarshal(data)
+		assert.Empty(t, ps.Logs)
+		kind, _ := tagValue(ps, TagSpanKind)
+		assert.Equal(t, "server", kind)
+	})
+	t.Run("test report truncated span", func(t *testing.T) {
+		report := newConnReport(&Config{Network: "tcp", Addr: "127.0.0.1:6083", ProtocolVersion: protoVersion1})
+		defer report.Close()
+		sp := t1.New("opt").SetTag(TagString(TagDBStatement, strings.Repeat("x", maxPackageSize))).(*Span)
+		assert.Nil(t, report.WriteSpan(newSpanData(sp)))
+		stats := report.Stats()
+		assert.Equal(t, int64(1), stats.Truncated)
+		assert.Equal(t, int64(0), stats.DroppedTooLarge)
+	})
+}
@@ -0,0 +1,207 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	zipkinSpansPath = "/api/v2/spans"
+
+	defaultExportTimeout  = 5 * time.Second
+	defaultHTTPBatchBytes = 1024 * 1024
+)
+
+// zipkinSpan zipkin v2 span 模型,见 https://zipkin.io/zipkin-api/#/default/post_spans
+type zipkinSpan struct {
+	TraceId        string             `json:"traceId"`
+	Id             string             `json:"id"`
+	ParentId       string             `json:"parentId,omitempty"`
+	Name           string             `json:"name,omitempty"`
+	Kind           string             `json:"kind,omitempty"`
+	Timestamp      int64              `json:"timestamp"`
+	Duration       int64              `json:"duration"`
+	Debug          bool               `json:"debug,omitempty"`
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint,omitempty"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint,omitempty"`
+	Annotations    []zipkinAnnotation `json:"annotations,omitempty"`
+	Tags           map[string]string  `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	Ipv4        string `json:"ipv4,omitempty"`
+	Ipv6        string `json:"ipv6,omitempty"`
+	Port        int    `json:"port,omitempty"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// newZipkinReport 创建以 zipkin v2 JSON 格式通过 HTTP 批量上报的上报器.
+func newZipkinReport(cfg *Config) *zipkinReport {
+	timeout := configTimeout(cfg, defaultExportTimeout)
+	batchSize, interval := batchConfig(cfg)
+	report := &zipkinReport{
+		url:    zipkinURL(cfg.Addr),
+		client: &http.Client{Timeout: timeout},
+	}
+	report.batch = newBatcher(batchSize, defaultHTTPBatchBytes, interval, report.flush)
+	// 批量包中 span 之间以逗号分隔
+	report.batch.overhead = func(int) int { return 1 }
+	go report.batch.daemon()
+	return report
+}
+
+// zipkinURL 地址未指定路径时使用 zipkin 默认的上报路径.
+func zipkinURL(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || (u.Path != "" && u.Path != "/") {
+		return addr
+	}
+	u.Path = zipkinSpansPath
+	return u.String()
+}
+
+type zipkinReport struct {
+	url    string
+	client *http.Client
+	batch  *batcher
+}
+
+func (z *zipkinReport) WriteSpan(sd *SpanData) error {
+	data, err := json.Marshal(toZipkinSpan(sd))
+	if err != nil {
+		atomic.AddInt64(&z.batch.stats.marshalErrors, 1)
+		return err
+	}
+	return z.batch.write(data)
+}
+
+// Stats 返回上报器的统计数据.
+func (z *zipkinReport) Stats() ReportStats {
+	return z.batch.Stats()
+}
+
+func (z *zipkinReport) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), z.client.Timeout+time.Second)
+	defer cancel()
+	return z.Shutdown(ctx)
+}
+
+// Flush 立即发送队列中的 span,等待发送完成或者 ctx 结束.
+func (z *zipkinReport) Flush(ctx context.Context) error {
+	return z.batch.flushContext(ctx)
+}
+
+// Shutdown 停止接收 span,等待队列中的 span 发送完成或者 ctx 结束.
+func (z *zipkinReport) Shutdown(ctx context.Context) error {
+	return z.batch.shutdown(ctx)
+}
+
+func (z *zipkinReport) flush(ctx context.Context, batch [][]byte) {
+	// 两端的方括号以及 span 之间的逗号
+	size := len(batch) + 1
+	for _, data := range batch {
+		size += len(data)
+	}
+	body := make([]byte, 0, size)
+	body = append(body, '[')
+	for i, data := range batch {
+		if i > 0 {
+			body = append(body, ',')
+		}
+		body = append(body, data...)
+	}
+	body = append(body, ']')
+	err := z.post(ctx, body)
+	z.batch.stats.result(len(batch), err)
+	if err != nil {
+		errorf("post spans to zipkin error: %s", err)
+	}
+}
+
+func (z *zipkinReport) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, z.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := z.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func toZipkinSpan(sd *SpanData) *zipkinSpan {
+	zs := &zipkinSpan{
+		TraceId:       zipkinID(sd.TraceId),
+		Id:            zipkinID(sd.SpanId),
+		Name:          sd.OperationName,
+		Timestamp:     sd.StartTime.UnixNano() / int64(time.Microsecond),
+		Duration:      int64(sd.Duration / time.Microsecond),
+		Debug:         sd.isDebug(),
+		LocalEndpoint: &zipkinEndpoint{ServiceName: sd.ServiceName},
+	}
+	if sd.ParentId != 0 {
+		zs.ParentId = zipkinID(sd.ParentId)
+	}
+	// zipkin 会丢弃耗时为 0 的 span
+	if zs.Duration == 0 {
+		zs.Duration = 1
+	}
+	remote := &zipkinEndpoint{}
+	for _, tag := range sd.spanTags() {
+		value := fmt.Sprint(tag.Value)
+		switch tag.Key {
+		case TagSpanKind:
+			zs.Kind = strings.ToUpper(value)
+		case TagPeerService:
+			remote.ServiceName = value
+		case TagPeerIPv4:
+			remote.Ipv4 = value
+		case TagPeerIPv6:
+			remote.Ipv6 = value
+		case TagPeerPort:
+			remote.Port, _ = strconv.Atoi(value)
+		default:
+			if zs.Tags == nil {
+				zs.Tags = make(map[string]string)
+			}
+			zs.Tags[tag.Key] = value
+		}
+	}
+	if *remote != (zipkinEndpoint{}) {
+		zs.RemoteEndpoint = remote
+	}
+	for _, log := range sd.Logs {
+		values := make([]string, len(log.Fields))
+		for i, field := range log.Fields {
+			values[i] = field.Key + "=" + logValueString(field.Value)
+		}
+		zs.Annotations = append(zs.Annotations, zipkinAnnotation{
+			Timestamp: log.Timestamp.UnixNano() / int64(time.Microsecond),
+			Value:     strings.Join(values, " "),
+		})
+	}
+	return zs
+}
+
+func zipkinID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
@@ -0,0 +1,69 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZipkinReport(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		spans []*zipkinSpan
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, zipkinSpansPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var batch []*zipkinSpan
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		spans = append(spans, batch...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	report := newReporter(&Config{Network: "zipkin", Addr: srv.URL})
+	t1 := NewTracer("service1", nil, report, true)
+	sp1 := t1.New("opt_server")
+	sp2 := sp1.Fork("", "opt_client").SetTag(TagString(TagPeerService, "redis"), TagString(TagPeerIPv4, "10.0.0.1"), TagInt(TagPeerPort, 6379))
+	sp2.SetLog(Log(LogEvent, "timeout"))
+	err := fmt.Errorf("redis timeout")
+	sp2.Finish(&err)
+	sp1.Finish(nil)
+	if err := report.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, "SERVER", server.Kind)
+	assert.Equal(t, "opt_server", server.Name)
+	assert.Equal(t, "service1", server.LocalEndpoint.ServiceName)
+	assert.Empty(t, server.ParentId)
+	assert.Nil(t, server.RemoteEndpoint)
+	assert.Len(t, server.TraceId, 16)
+
+	assert.Equal(t, "CLIENT", client.Kind)
+	assert.Equal(t, server.TraceId, client.TraceId)
+	assert.Equal(t, server.Id, client.ParentId)
+	assert.Equal(t, &zipkinEndpoint{ServiceName: "redis", Ipv4: "10.0.0.1", Port: 6379}, client.RemoteEndpoint)
+	assert.Equal(t, "true", client.Tags[TagError])
+	assert.True(t, client.Duration > 0)
+	assert.Len(t, client.Annotations, 2)
+	assert.Equal(t, "event=timeout", client.Annotations[0].Value)
+	assert.Equal(t, "message=redis timeout", client.Annotations[1].Value)
+}
+
+func TestZipkinURL(t *testing.T) {
+	assert.Equal(t, "http://127.0.0.1:9411/api/v2/spans", zipkinURL("http://127.0.0.1:9411"))
+	assert.Equal(t, "http://127.0.0.1:9411/api/v2/spans", zipkinURL("http://127.0.0.1:9411/"))
+	assert.Equal(t, "http://127.0.0.1:9411/custom", zipkinURL("http://127.0.0.1:9411/custom"))
+}